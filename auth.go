@@ -2,15 +2,58 @@ package gitamite
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"golang.org/x/crypto/openpgp"
 	"io/ioutil"
+	"time"
 )
 
+// how far a request's timestamp can drift from the server's clock
+const authRequestWindow = 5 * time.Minute
+
 type AuthRequest struct {
 	Signature []byte
 	Data      interface{}
+
+	// signed along with Data so a captured request can't be replayed later
+	// or against another server/endpoint
+	Timestamp int64
+	Nonce     string
+	Server    string
+	Method    string
+
+	// Data exactly as it was received, since it has to be signed
+	// byte-for-byte and re-marshaling a map reorders the keys
+	rawData json.RawMessage
+}
+
+func (r *AuthRequest) UnmarshalJSON(b []byte) error {
+	type plain AuthRequest
+	var p struct {
+		plain
+		Data json.RawMessage
+	}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+
+	*r = AuthRequest(p.plain)
+	r.rawData = p.Data
+	if len(p.Data) > 0 {
+		return json.Unmarshal(p.Data, &r.Data)
+	}
+	return nil
+}
+
+// NonceStore remembers the nonces of requests that have already been
+// accepted
+type NonceStore interface {
+	// UseNonce records nonce until expires, failing if it's been seen before
+	UseNonce(nonce string, expires time.Time) error
 }
 
 // TODO make sure to memoize
@@ -28,37 +71,90 @@ func ReadKeyringFile(path string) (openpgp.EntityList, error) {
 	return keyring, err
 }
 
-func (r AuthRequest) VerifyRequest() error {
+func (r AuthRequest) signedBlob() []byte {
+	data := r.Data
+	if r.rawData != nil {
+		data = r.rawData
+	}
+	blob, _ := json.Marshal(struct {
+		Data      interface{}
+		Timestamp int64
+		Nonce     string
+		Server    string
+		Method    string
+	}{
+		data,
+		r.Timestamp,
+		r.Nonce,
+		r.Server,
+		r.Method,
+	})
+	return blob
+}
+
+// VerifyRequest checks the signature and that the request was made for
+// this server and method recently, returning the signer. The nonce is only
+// recorded if nonces isn't nil, so a request can be checked more than once
+// where that's needed
+func (r AuthRequest) VerifyRequest(server, method string, nonces NonceStore) (*openpgp.Entity, error) {
 	// TODO: move this to models.go
 	p, err := GetConfigValue("pubkeyring_path")
 	if err != nil {
-		return fmt.Errorf("failed to read public keyring")
+		return nil, fmt.Errorf("failed to read public keyring")
 	}
 
 	keyring, _ := ReadKeyringFile(p)
 
-	blob, _ := json.Marshal(r.Data) // FIXME: unmarshaling then marshaling again
-	if _, err := openpgp.CheckArmoredDetachedSignature(keyring,
-		bytes.NewReader(blob),
-		bytes.NewReader(r.Signature)); err != nil {
-		return err
+	signer, err := openpgp.CheckArmoredDetachedSignature(keyring,
+		bytes.NewReader(r.signedBlob()),
+		bytes.NewReader(r.Signature))
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	if r.Server != server || r.Method != method {
+		return nil, fmt.Errorf("request was meant for %s %s", r.Method, r.Server)
+	}
+
+	t := time.Unix(r.Timestamp, 0)
+	if d := time.Since(t); d > authRequestWindow || d < -authRequestWindow {
+		return nil, fmt.Errorf("request expired")
+	}
+
+	if nonces != nil {
+		if r.Nonce == "" {
+			return nil, fmt.Errorf("request is missing a nonce")
+		}
+		if err := nonces.UseNonce(r.Nonce, t.Add(authRequestWindow)); err != nil {
+			return nil, err
+		}
+	}
+	return signer, nil
 }
 
-func CreateAuthRequest(data interface{}) (AuthRequest, error) {
+// CreateAuthRequest signs data for a request to method on server
+// (as host[:port])
+func CreateAuthRequest(data interface{}, server, method string) (AuthRequest, error) {
 	p, err := GetConfigValue("privkeyring_file")
 	if err != nil {
-		return AuthRequest{}, nil
+		return AuthRequest{}, err
 	}
 
 	keyring, _ := ReadKeyringFile(p)
 
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return AuthRequest{}, err
+	}
+
 	r := AuthRequest{}
 	r.Data = data
+	r.Timestamp = time.Now().Unix()
+	r.Nonce = hex.EncodeToString(nonce)
+	r.Server = server
+	r.Method = method
 	sig := bytes.NewBufferString("")
-	blob, _ := json.Marshal(data)
-	err = openpgp.ArmoredDetachSign(sig, keyring[0], bytes.NewReader(blob), nil)
+	err = openpgp.ArmoredDetachSign(sig, keyring[0], bytes.NewReader(r.signedBlob()), nil)
 	if err != nil {
 		return AuthRequest{}, err
 	}
@@ -67,3 +163,30 @@ func CreateAuthRequest(data interface{}) (AuthRequest, error) {
 
 	return r, nil
 }
+
+// Token packs the request into a string so it can be sent where only a
+// password fits (i.e. HTTP basic auth from a git credential helper)
+func (r AuthRequest) Token() (string, error) {
+	blob, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(blob), nil
+}
+
+func ParseAuthToken(token string) (*AuthRequest, error) {
+	blob, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var r AuthRequest
+	if err := json.Unmarshal(blob, &r); err != nil {
+		return nil, err
+	}
+
+	if len(r.Signature) == 0 || r.Data == nil {
+		return nil, fmt.Errorf("need data and signature")
+	}
+	return &r, nil
+}
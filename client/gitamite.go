@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -10,6 +11,8 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func errx(code int, s string) {
@@ -17,26 +20,22 @@ func errx(code int, s string) {
 	os.Exit(code)
 }
 
-func makeRequest(args []string, f func(url.URL, []byte) *http.Response) {
+func serverAddr() string {
 	host, err := gitamite.GetConfigValue("server_addr")
 	if err != nil {
 		errx(1, err.Error())
 	}
+	return host
+}
+
+func makeRequest(p string, data interface{}, method string, f func(url.URL, []byte) *http.Response) {
 	u := url.URL{
 		Scheme: "http",
-		Host:   host,
-		Path:   "/repo",
-	}
-
-	if len(args) < 1 {
-		errx(1, "need a name")
+		Host:   serverAddr(),
+		Path:   p,
 	}
 
-	a, err := gitamite.CreateAuthRequest(struct {
-		Name string
-	}{
-		args[0],
-	})
+	a, err := gitamite.CreateAuthRequest(data, u.Host, method)
 	if err != nil {
 		errx(1, err.Error())
 	}
@@ -50,8 +49,24 @@ func makeRequest(args []string, f func(url.URL, []byte) *http.Response) {
 	}
 }
 
+// repos can be in namespaces (team/project), and people tend to copy the
+// name out of the clone url
+func repoName(arg string) string {
+	return strings.TrimSuffix(strings.Trim(arg, "/"), ".git")
+}
+
 func createRepoRequest(ctx climax.Context) int {
-	makeRequest(ctx.Args, func(u url.URL, blob []byte) *http.Response {
+	if len(ctx.Args) < 1 {
+		errx(1, "need a name")
+	}
+
+	makeRequest("/repo", struct {
+		Name    string
+		Private bool
+	}{
+		repoName(ctx.Args[0]),
+		ctx.Is("private"),
+	}, http.MethodPost, func(u url.URL, blob []byte) *http.Response {
 		r, err := http.Post(u.String(), "application/json", bytes.NewReader(blob))
 		if err != nil {
 			errx(3, err.Error())
@@ -69,7 +84,11 @@ func deleteRepoRequest(ctx climax.Context) int {
 		errx(0, "Not deleting repo")
 	}
 
-	makeRequest(ctx.Args, func(u url.URL, blob []byte) *http.Response {
+	makeRequest("/repo", struct {
+		Name string
+	}{
+		repoName(ctx.Args[0]),
+	}, http.MethodDelete, func(u url.URL, blob []byte) *http.Response {
 		d, _ := http.NewRequest(http.MethodDelete, u.String(), bytes.NewReader(blob))
 		d.Header.Set("Content-Type", "application/json")
 		client := &http.Client{}
@@ -82,18 +101,210 @@ func deleteRepoRequest(ctx climax.Context) int {
 	return 0
 }
 
+func aclRequest(ctx climax.Context) int {
+	if len(ctx.Args) < 1 {
+		errx(1, "need a name")
+	}
+
+	data := map[string]interface{}{"Name": repoName(ctx.Args[0])}
+	if ctx.Is("private") {
+		data["Private"] = true
+	}
+	if ctx.Is("public") {
+		data["Private"] = false
+	}
+	if c, ok := ctx.Get("collaborators"); ok {
+		data["Collaborators"] = []string{}
+		if c != "" {
+			data["Collaborators"] = strings.Split(c, ",")
+		}
+	}
+
+	makeRequest("/repo", data, http.MethodPut, func(u url.URL, blob []byte) *http.Response {
+		d, _ := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(blob))
+		d.Header.Set("Content-Type", "application/json")
+		client := &http.Client{}
+		r, err := client.Do(d)
+		if err != nil {
+			errx(3, err.Error())
+		}
+		return r
+	})
+	return 0
+}
+
+func defaultBranchRequest(ctx climax.Context) int {
+	if len(ctx.Args) < 2 {
+		errx(1, "need a repo and a branch")
+	}
+
+	makeRequest("/repo", struct {
+		Name          string
+		DefaultBranch string
+	}{
+		repoName(ctx.Args[0]),
+		ctx.Args[1],
+	}, http.MethodPut, jsonRequest(http.MethodPut))
+	return 0
+}
+
+func blameIgnoreRequest(ctx climax.Context) int {
+	if len(ctx.Args) < 1 {
+		errx(1, "need a repo")
+	}
+
+	makeRequest("/repo", struct {
+		Name            string
+		BlameIgnoreRevs []string
+	}{
+		repoName(ctx.Args[0]),
+		append([]string{}, ctx.Args[1:]...),
+	}, http.MethodPut, jsonRequest(http.MethodPut))
+	return 0
+}
+
+// sends the signed request as json with any method
+func jsonRequest(method string) func(url.URL, []byte) *http.Response {
+	return func(u url.URL, blob []byte) *http.Response {
+		d, _ := http.NewRequest(method, u.String(), bytes.NewReader(blob))
+		d.Header.Set("Content-Type", "application/json")
+		client := &http.Client{}
+		r, err := client.Do(d)
+		if err != nil {
+			errx(3, err.Error())
+		}
+		return r
+	}
+}
+
+func releaseRequest(ctx climax.Context) int {
+	if len(ctx.Args) < 2 {
+		errx(1, "need a repo and a tag")
+	}
+
+	data := map[string]interface{}{"Name": repoName(ctx.Args[0]), "Tag": ctx.Args[1]}
+	if ctx.Is("delete") {
+		makeRequest("/release", data, http.MethodDelete, jsonRequest(http.MethodDelete))
+		return 0
+	}
+
+	data["Notes"] = ""
+	if f, ok := ctx.Get("notes"); ok {
+		var notes []byte
+		var err error
+		if f == "-" {
+			notes, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			notes, err = ioutil.ReadFile(f)
+		}
+		if err != nil {
+			errx(1, err.Error())
+		}
+		data["Notes"] = string(notes)
+	}
+	makeRequest("/release", data, http.MethodPut, jsonRequest(http.MethodPut))
+	return 0
+}
+
+func uploadRequest(ctx climax.Context) int {
+	if len(ctx.Args) < 3 {
+		errx(1, "need a repo, a tag and files")
+	}
+
+	for _, f := range ctx.Args[2:] {
+		data := map[string]interface{}{"Name": repoName(ctx.Args[0]), "Tag": ctx.Args[1], "File": filepath.Base(f)}
+		if ctx.Is("delete") {
+			makeRequest("/release", data, http.MethodDelete, jsonRequest(http.MethodDelete))
+			continue
+		}
+
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			errx(1, err.Error())
+		}
+		// []byte goes as base64
+		data["Content"] = content
+		makeRequest("/release/files", data, http.MethodPost, jsonRequest(http.MethodPost))
+		fmt.Printf("uploaded %s\n", f)
+	}
+	return 0
+}
+
+// prints a short-lived token to use as the password when a browser asks
+// for one on a private repo
+func tokenRequest(ctx climax.Context) int {
+	a, err := gitamite.CreateAuthRequest(struct{}{}, serverAddr(), "browse")
+	if err != nil {
+		errx(1, err.Error())
+	}
+
+	token, err := a.Token()
+	if err != nil {
+		errx(1, err.Error())
+	}
+	fmt.Println(token)
+	return 0
+}
+
+// git credential helper (see gitcredentials(7)) that answers with a signed
+// request for the repo being pushed to or cloned. needs credential.useHttpPath
+func credentialHelper(ctx climax.Context) int {
+	if len(ctx.Args) < 1 || ctx.Args[0] != "get" {
+		return 0
+	}
+
+	attrs := make(map[string]string)
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() && s.Text() != "" {
+		kv := strings.SplitN(s.Text(), "=", 2)
+		if len(kv) == 2 {
+			attrs[kv[0]] = kv[1]
+		}
+	}
+
+	name := strings.TrimSuffix(strings.TrimPrefix(attrs["path"], "repo/"), ".git")
+	if name == "" {
+		errx(1, "no repo path given, set credential.useHttpPath")
+	}
+
+	a, err := gitamite.CreateAuthRequest(struct {
+		Name string
+	}{
+		name,
+	}, attrs["host"], "git")
+	if err != nil {
+		errx(1, err.Error())
+	}
+
+	token, err := a.Token()
+	if err != nil {
+		errx(1, err.Error())
+	}
+
+	fmt.Printf("username=gitamite\npassword=%s\n", token)
+	return 0
+}
+
 func main() {
-	gitamite.LoadConfig()
+	gitamite.LoadConfig(gitamite.Client)
 
 	cli := climax.New("gitamite")
 	cli.Brief = "gitamite client"
 	cli.Version = "1.0"
 
 	createCmd := climax.Command{
-		Name:   "create",
-		Brief:  "creates a new repo",
-		Usage:  "[REPO]",
-		Help:   "creates a new repo",
+		Name:  "create",
+		Brief: "creates a new repo",
+		Usage: "[--private] [NAMESPACE/]REPO",
+		Help:  "creates a new repo, owned by you. Namespaces (team/project) are created as needed",
+		Flags: []climax.Flag{
+			{
+				Name:  "private",
+				Short: "p",
+				Usage: "--private",
+				Help:  "only the owner and collaborators can see the repo",
+			},
+		},
 		Handle: createRepoRequest,
 	}
 	cli.AddCommand(createCmd)
@@ -101,12 +312,114 @@ func main() {
 	deleteCmd := climax.Command{
 		Name:   "delete",
 		Brief:  "deletes a repo",
-		Usage:  "[REPO]",
+		Usage:  "[NAMESPACE/]REPO",
 		Help:   "deletes a repo",
 		Handle: deleteRepoRequest,
 	}
 	cli.AddCommand(deleteCmd)
 
+	aclCmd := climax.Command{
+		Name:  "acl",
+		Brief: "changes who can access a repo",
+		Usage: "[--private|--public] [--collaborators=EMAIL,...] [REPO]",
+		Help:  "changes a repo's visibility and collaborators (replacing the old list). only the owner can do this",
+		Flags: []climax.Flag{
+			{
+				Name:  "private",
+				Usage: "--private",
+				Help:  "hide the repo from everyone but the owner and collaborators",
+			},
+			{
+				Name:  "public",
+				Usage: "--public",
+				Help:  "make the repo visible to everyone",
+			},
+			{
+				Name:     "collaborators",
+				Short:    "c",
+				Usage:    "--collaborators=EMAIL,...",
+				Help:     "emails (from the keyring) that can push to the repo",
+				Variable: true,
+			},
+		},
+		Handle: aclRequest,
+	}
+	cli.AddCommand(aclCmd)
+
+	defaultBranchCmd := climax.Command{
+		Name:   "default-branch",
+		Brief:  "changes a repo's default branch",
+		Usage:  "REPO BRANCH",
+		Help:   "points the repo's HEAD at BRANCH, which is what's shown and cloned by default. only the owner can do this",
+		Handle: defaultBranchRequest,
+	}
+	cli.AddCommand(defaultBranchCmd)
+
+	blameIgnoreCmd := climax.Command{
+		Name:   "blame-ignore",
+		Brief:  "sets the commits blame looks past",
+		Usage:  "REPO [COMMIT...]",
+		Help:   "replaces the list of commits (reformatting and the like) blame passes lines back past, on top of the repo's .git-blame-ignore-revs. no commits clears it. only the owner can do this",
+		Handle: blameIgnoreRequest,
+	}
+	cli.AddCommand(blameIgnoreCmd)
+
+	releaseCmd := climax.Command{
+		Name:  "release",
+		Brief: "makes a tag into a release",
+		Usage: "[--notes=FILE] [--delete] REPO TAG",
+		Help:  "makes a tag into a release, or replaces its notes. anyone who can push to the repo can do this",
+		Flags: []climax.Flag{
+			{
+				Name:     "notes",
+				Short:    "n",
+				Usage:    "--notes=FILE",
+				Help:     "markdown release notes (- for stdin)",
+				Variable: true,
+			},
+			{
+				Name:  "delete",
+				Usage: "--delete",
+				Help:  "delete the release and its files (the tag stays)",
+			},
+		},
+		Handle: releaseRequest,
+	}
+	cli.AddCommand(releaseCmd)
+
+	uploadCmd := climax.Command{
+		Name:  "upload",
+		Brief: "attaches files to a release",
+		Usage: "[--delete] REPO TAG FILE...",
+		Help:  "attaches files to a tag's release (making the release if needed), replacing files with the same name",
+		Flags: []climax.Flag{
+			{
+				Name:  "delete",
+				Usage: "--delete",
+				Help:  "remove the files from the release instead",
+			},
+		},
+		Handle: uploadRequest,
+	}
+	cli.AddCommand(uploadCmd)
+
+	tokenCmd := climax.Command{
+		Name:   "token",
+		Brief:  "prints a token for browsing private repos",
+		Help:   "prints a token to use as the password when the browser asks for one. it expires after a few minutes",
+		Handle: tokenRequest,
+	}
+	cli.AddCommand(tokenCmd)
+
+	credentialCmd := climax.Command{
+		Name:   "credential",
+		Brief:  "git credential helper for pushing over http",
+		Usage:  "get",
+		Help:   "git credential helper, set with `git config credential.helper 'gitamite credential'`",
+		Handle: credentialHelper,
+	}
+	cli.AddCommand(credentialCmd)
+
 	cli.Run()
 	return
 }
@@ -0,0 +1,494 @@
+// Package api has the JSON representations served under /api/v1 (or to any
+// page requested with `Accept: application/json`). These are the stable
+// schemas; fields can be added within v1 but never renamed or removed.
+package api
+
+import (
+	"github.com/charles-l/gitamite/server/model"
+	"github.com/libgit2/git2go"
+
+	"path"
+	"strings"
+	"time"
+)
+
+// Repo is a repository, as listed on / and /repo/:repo. DefaultBranch is
+// the branch HEAD points to, which might not exist yet
+type Repo struct {
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	Private       bool   `json:"private"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+// Namespace is a directory of repos, as listed on /repo/NAMESPACE.
+// Namespaces are the full names of the namespaces in it
+type Namespace struct {
+	Name       string   `json:"name"`
+	Namespaces []string `json:"namespaces"`
+	Repos      []Repo   `json:"repos"`
+}
+
+// Verification is the status of a commit or tag's GPG signature:
+// "verified", "unknown key", "bad signature" or "unsigned". Signer is the
+// key that made a verified signature
+type Verification struct {
+	Status string `json:"status"`
+	Signer *User  `json:"signer"`
+}
+
+// Divergence is how many commits a branch has that Base doesn't (Ahead)
+// and the other way around (Behind)
+type Divergence struct {
+	Base   string `json:"base"`
+	Ahead  int    `json:"ahead"`
+	Behind int    `json:"behind"`
+}
+
+// Ref is a branch or tag (Type "branch" or "tag"). Target is the hash of
+// the commit it points to. Signature is the tag's signature for annotated
+// tags, otherwise the target commit's. Branches have their Divergence from
+// the default branch
+type Ref struct {
+	Name       string       `json:"name"`
+	ShortName  string       `json:"short_name"`
+	Target     string       `json:"target"`
+	Type       string       `json:"type"`
+	Message    string       `json:"message,omitempty"`
+	Signature  Verification `json:"signature"`
+	Divergence *Divergence  `json:"divergence,omitempty"`
+}
+
+// Tag is a tag on its own page (/repo/:repo/tags). Tagger is null and
+// Message empty for lightweight tags, and Date is the commit's then.
+// Release is whether the tag has a release
+type Tag struct {
+	Name      string       `json:"name"`
+	Target    string       `json:"target"`
+	Tagger    *Signature   `json:"tagger"`
+	Date      time.Time    `json:"date"`
+	Message   string       `json:"message"`
+	Signature Verification `json:"signature"`
+	Release   bool         `json:"release"`
+}
+
+// ReleaseFile is a file attached to a release
+type ReleaseFile struct {
+	Name string    `json:"name"`
+	Size int64     `json:"size"`
+	Date time.Time `json:"date"`
+}
+
+// Release is a tag with release notes (markdown) and files
+type Release struct {
+	Tag   Tag           `json:"tag"`
+	Notes string        `json:"notes"`
+	Files []ReleaseFile `json:"files"`
+}
+
+// User is a key from the server's keyring. PublicKey is only set on
+// /user/:email
+type User struct {
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// Signature is the name/email/date git records for authors and committers,
+// whether or not they're in the keyring
+type Signature struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	Date  time.Time `json:"date"`
+}
+
+// Commit is a commit. User is the committer's keyring entry, or null
+type Commit struct {
+	Hash      string       `json:"hash"`
+	Summary   string       `json:"summary"`
+	Message   string       `json:"message"`
+	Author    Signature    `json:"author"`
+	Committer Signature    `json:"committer"`
+	Parents   []string     `json:"parents"`
+	User      *User        `json:"user"`
+	Signature Verification `json:"signature"`
+}
+
+// Log is a page of commits, newest first. Next is the after cursor for the
+// page after this one, or "" if this is the last
+type Log struct {
+	Commits []Commit `json:"commits"`
+	Next    string   `json:"next"`
+}
+
+// History is a page of the commits that changed a file or directory, paged
+// like Log
+type History struct {
+	Entries []HistoryEntry `json:"entries"`
+	Next    string         `json:"next"`
+}
+
+// HistoryEntry is a commit that changed a file or directory. Path is what
+// it was called in that commit, and OldPath what it was renamed from, if
+// this commit renamed it
+type HistoryEntry struct {
+	Commit  Commit `json:"commit"`
+	Path    string `json:"path"`
+	OldPath string `json:"old_path,omitempty"`
+}
+
+// TreeEntry is a file ("blob"), directory ("tree") or submodule ("commit")
+type TreeEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Hash string `json:"hash"`
+}
+
+// Tree is the listing of a directory at a commit
+type Tree struct {
+	Path    string      `json:"path"`
+	Entries []TreeEntry `json:"entries"`
+}
+
+// Blob is a file's contents. Type is the name of the language it's
+// highlighted as
+type Blob struct {
+	Path    string `json:"path"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
+
+// BlameLine is a line of a blamed file, numbered from 1. Author is from
+// the commit, and User is their keyring entry, or null. Skipped is the
+// ignored commit (see .git-blame-ignore-revs) the line was passed back
+// past, if any
+type BlameLine struct {
+	Line    int    `json:"line"`
+	Content string `json:"content"`
+	Commit  string `json:"commit"`
+	Author  string `json:"author"`
+	Email   string `json:"email"`
+	User    *User  `json:"user"`
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// Blame is a file with who last touched each line
+type Blame struct {
+	Path  string      `json:"path"`
+	Lines []BlameLine `json:"lines"`
+}
+
+// DiffLine is a line of a hunk. Origin is "+", "-" or " ". Line numbers
+// are -1 if the line isn't on that side
+type DiffLine struct {
+	Origin    string `json:"origin"`
+	OldLineno int    `json:"old_lineno"`
+	NewLineno int    `json:"new_lineno"`
+	Content   string `json:"content"`
+}
+
+// DiffHeader is a hunk's @@ line, and the index in Lines its hunk starts
+// at
+type DiffHeader struct {
+	Header string `json:"header"`
+	Start  int    `json:"start"`
+}
+
+// DiffHunk is the change to one file. Status is "added", "deleted",
+// "modified", "renamed" or "copied", and Similarity (0-100) is how alike a
+// renamed or copied file is to OldPath. Lines are every hunk's lines, and
+// Headers says where each hunk starts. Header is the first one's
+type DiffHunk struct {
+	OldPath    string       `json:"old_path"`
+	NewPath    string       `json:"new_path"`
+	Status     string       `json:"status"`
+	Similarity int          `json:"similarity,omitempty"`
+	Header     string       `json:"header"`
+	Headers    []DiffHeader `json:"headers"`
+	Lines      []DiffLine   `json:"lines"`
+}
+
+// Diff is the change from From (null for root commits) to To
+type Diff struct {
+	From  *string    `json:"from"`
+	To    string     `json:"to"`
+	Stats string     `json:"stats"`
+	Hunks []DiffHunk `json:"hunks"`
+}
+
+// Comparison is what Head would bring into Base: Ahead commits since
+// MergeBase (Behind is how many Base has that Head doesn't), and the diff
+// from MergeBase to Head. Commits is at most 500 long
+type Comparison struct {
+	Base      string   `json:"base"`
+	Head      string   `json:"head"`
+	MergeBase string   `json:"merge_base"`
+	Ahead     int      `json:"ahead"`
+	Behind    int      `json:"behind"`
+	Commits   []Commit `json:"commits"`
+	Diff      Diff     `json:"diff"`
+}
+
+// SearchLine is a line of a file that matched a search, numbered from 1.
+// Long lines are cut down to the part around the match
+type SearchLine struct {
+	Line    int    `json:"line"`
+	Content string `json:"content"`
+}
+
+// SearchResult is a file on a repo's default branch (at Commit) that
+// matched a search. Lines is the first few lines that matched, and More
+// how many other lines did. Lines is empty when only the path and
+// language were searched
+type SearchResult struct {
+	Repo     string       `json:"repo"`
+	Commit   string       `json:"commit"`
+	Path     string       `json:"path"`
+	Language string       `json:"language"`
+	Lines    []SearchLine `json:"lines"`
+	More     int          `json:"more"`
+}
+
+// CommitHit is a commit that matched a commit search. Date is the commit
+// date
+type CommitHit struct {
+	Repo    string    `json:"repo"`
+	Hash    string    `json:"hash"`
+	Summary string    `json:"summary"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+}
+
+// Todo is a TODO/FIXME/XXX/HACK comment. Author and Email are from blame
+type Todo struct {
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	Kind   string `json:"kind"`
+	Text   string `json:"text"`
+	Author string `json:"author"`
+	Email  string `json:"email"`
+}
+
+func MakeRepo(r *model.Repo) Repo {
+	return Repo{r.Name, strings.TrimSpace(r.Description), r.ACL().Private, r.DefaultBranch()}
+}
+
+func MakeRepos(repos []*model.Repo) []Repo {
+	r := make([]Repo, 0, len(repos))
+	for _, e := range repos {
+		r = append(r, MakeRepo(e))
+	}
+	return r
+}
+
+func MakeNamespace(name string, namespaces []string, repos []*model.Repo) Namespace {
+	if namespaces == nil {
+		namespaces = []string{}
+	}
+	return Namespace{name, namespaces, MakeRepos(repos)}
+}
+
+func MakeVerification(v model.Verification) Verification {
+	return Verification{v.Status.String(), MakeUser(v.Signer)}
+}
+
+func MakeRefs(refs []*model.Ref, tags []*model.Tag, base string, divergence map[string]model.Divergence) []Ref {
+	r := make([]Ref, 0, len(refs)+len(tags))
+	for _, e := range refs {
+		ref := Ref{e.Name(), e.NiceName(), e.Target().String(), "branch", "", MakeVerification(e.Verify()), nil}
+		if d, ok := divergence[e.Name()]; ok {
+			ref.Divergence = &Divergence{base, d.Ahead, d.Behind}
+		}
+		r = append(r, ref)
+	}
+	for _, t := range tags {
+		r = append(r, Ref{t.Name, t.NiceName(), t.Commit.Hash(), "tag", t.Message(), MakeVerification(t.Verify()), nil})
+	}
+	return r
+}
+
+func MakeTag(t *model.Tag, release bool) Tag {
+	var tagger *Signature
+	if s := t.Tagger(); s != nil {
+		sig := makeSignature(s)
+		tagger = &sig
+	}
+	return Tag{t.ShortName(), t.Commit.Hash(), tagger, t.Date(), t.Message(), MakeVerification(t.Verify()), release}
+}
+
+// MakeTags takes whether each tag (by name) has a release
+func MakeTags(tags []*model.Tag, releases map[string]bool) []Tag {
+	r := make([]Tag, 0, len(tags))
+	for _, t := range tags {
+		r = append(r, MakeTag(t, releases[t.Name]))
+	}
+	return r
+}
+
+func MakeRelease(rel *model.Release) Release {
+	files := make([]ReleaseFile, 0, len(rel.Files))
+	for _, f := range rel.Files {
+		files = append(files, ReleaseFile{f.Name, f.Size, f.Time})
+	}
+	return Release{MakeTag(rel.Tag, true), rel.Notes, files}
+}
+
+func MakeReleases(releases []*model.Release) []Release {
+	r := make([]Release, 0, len(releases))
+	for _, e := range releases {
+		r = append(r, MakeRelease(e))
+	}
+	return r
+}
+
+func MakeSearchResults(results []model.SearchResult) []SearchResult {
+	r := make([]SearchResult, 0, len(results))
+	for _, e := range results {
+		lines := make([]SearchLine, 0, len(e.Lines))
+		for _, l := range e.Lines {
+			content := ""
+			for _, s := range l.Spans {
+				content += s.Text
+			}
+			lines = append(lines, SearchLine{l.Number, content})
+		}
+		r = append(r, SearchResult{e.Repo.Name, e.Commit.Hash(), e.Path, e.Lang, lines, e.More})
+	}
+	return r
+}
+
+func MakeCommitHits(hits []model.CommitHit) []CommitHit {
+	r := make([]CommitHit, 0, len(hits))
+	for _, h := range hits {
+		r = append(r, CommitHit{h.Repo.Name, h.Hash, h.Summary, h.Author, h.Email, h.Date})
+	}
+	return r
+}
+
+// MakeUser returns nil for nil, so unknown users come out as null
+func MakeUser(u *model.User) *User {
+	if u == nil {
+		return nil
+	}
+	return &User{Name: strings.TrimSpace(u.Name), Email: u.Email}
+}
+
+func makeSignature(s *git.Signature) Signature {
+	return Signature{s.Name, s.Email, s.When}
+}
+
+func MakeCommit(c *model.Commit) Commit {
+	parents := make([]string, 0, c.ParentCount())
+	for i := uint(0); i < c.ParentCount(); i++ {
+		parents = append(parents, c.ParentId(i).String())
+	}
+	return Commit{
+		c.Hash(),
+		c.Summary(),
+		c.Message(),
+		makeSignature(c.Author()),
+		makeSignature(c.Committer()),
+		parents,
+		MakeUser(c.User),
+		MakeVerification(c.Verify()),
+	}
+}
+
+func MakeCommits(commits []*model.Commit) []Commit {
+	r := make([]Commit, 0, len(commits))
+	for _, c := range commits {
+		r = append(r, MakeCommit(c))
+	}
+	return r
+}
+
+func MakeLog(commits []*model.Commit, next string) Log {
+	return Log{MakeCommits(commits), next}
+}
+
+func MakeHistory(entries []model.HistoryEntry, next string) History {
+	r := make([]HistoryEntry, 0, len(entries))
+	for _, e := range entries {
+		r = append(r, HistoryEntry{MakeCommit(e.Commit), e.Path, e.OldPath})
+	}
+	return History{r, next}
+}
+
+func MakeTree(treePath string, entries []model.TreeEntry) Tree {
+	t := Tree{treePath, make([]TreeEntry, 0, len(entries))}
+	for _, e := range entries {
+		if e.Name == ".." {
+			continue
+		}
+		t.Entries = append(t.Entries, TreeEntry{
+			e.Name,
+			strings.TrimPrefix(path.Join(e.DirPath, e.Name), "/"),
+			strings.ToLower(e.Type.String()),
+			e.Id.String(),
+		})
+	}
+	return t
+}
+
+func MakeBlob(b *model.Blob) Blob {
+	return Blob{b.Path, b.Type, string(b.ByteArray())}
+}
+
+func MakeBlame(b *model.Blame) Blame {
+	r := Blame{b.Path, make([]BlameLine, 0, len(b.Data))}
+	for _, h := range b.Hunks {
+		skipped := ""
+		if h.Skipped != nil {
+			skipped = h.Skipped.Hash()
+		}
+		for i := h.Start; i < h.Start+h.Lines && i <= len(b.Data); i++ {
+			r.Lines = append(r.Lines, BlameLine{i, string(b.Data[i-1]), h.Commit.Hash(), h.Signature.Name, h.Signature.Email, MakeUser(h.User), skipped})
+		}
+	}
+	return r
+}
+
+func MakeDiff(d *model.Diff) Diff {
+	r := Diff{To: d.CommitA.Hash(), Stats: d.Stats, Hunks: make([]DiffHunk, 0, len(d.Hunks))}
+	if d.CommitB != nil {
+		from := d.CommitB.Hash()
+		r.From = &from
+	}
+
+	for _, h := range d.Hunks {
+		hunk := DiffHunk{OldPath: h.OldPath, NewPath: h.NewPath, Status: h.Status, Similarity: h.Similarity, Headers: make([]DiffHeader, 0, len(h.Headers)), Lines: make([]DiffLine, 0, len(h.Lines))}
+		for _, hd := range h.Headers {
+			hunk.Headers = append(hunk.Headers, DiffHeader{hd.Header, hd.Start})
+		}
+		if len(h.Headers) > 0 {
+			hunk.Header = h.Headers[0].Header
+		}
+		for _, l := range h.Lines {
+			hunk.Lines = append(hunk.Lines, DiffLine{string(rune(l.Origin)), l.OldLineno, l.NewLineno, l.Content})
+		}
+		r.Hunks = append(r.Hunks, hunk)
+	}
+	return r
+}
+
+func MakeComparison(c *model.Comparison) Comparison {
+	return Comparison{
+		c.Base.Hash(),
+		c.Head.Hash(),
+		c.MergeBase.Hash(),
+		c.Ahead,
+		c.Behind,
+		MakeCommits(c.Commits),
+		MakeDiff(&c.Diff),
+	}
+}
+
+func MakeTodos(todos []model.Todo) []Todo {
+	r := make([]Todo, 0, len(todos))
+	for _, t := range todos {
+		r = append(r, Todo(t))
+	}
+	return r
+}
@@ -28,7 +28,6 @@ import (
 	"log"
 	"net/http"
 	"path"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -53,7 +52,6 @@ func main() {
 	log.Printf("loaded DB")
 
 	gitamite.LoadConfig(gitamite.Server)
-	repos := make(map[string]*model.Repo)
 
 	repoDir, err := gitamite.GetConfigValue("repo_dir")
 	if err != nil {
@@ -61,27 +59,40 @@ func main() {
 		// we really don't want to accidentally overwrite stuff in /
 		return
 	}
-	matches, _ := filepath.Glob(path.Join(repoDir, "*"))
-	for _, p := range matches {
-		log.Printf("loading repo from %s\n", p)
-		name := filepath.Base(p)
-		repos[name] = model.LoadRepository(name, p)
+	repos := model.NewRepoRegistry(repoDir)
+	go func() {
+		if err := repos.Watch(); err != nil {
+			log.Printf("not watching %s for new repos: %s", repoDir, err)
+		}
+	}()
+
+	search := model.NewSearchIndex()
+	go search.Run()
+	for _, r := range repos.All() {
+		search.Queue(r)
 	}
 
+	go serveSSH(repos, search)
+
 	e := echo.New()
 	e.Use(func(h echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			cc := &context.Context{c, repos}
+			cc := &context.Context{c, repos, search}
 			return h(cc)
 		}
 	})
 
 	e.Pre(middleware.RemoveTrailingSlash())
+	e.Pre(helper.RepoNamespaces(repos))
+	e.Pre(helper.RefNames(repos))
 
 	templateFuncs := template.FuncMap{
 		"humanizeTime": func(t time.Time) string {
 			return humanize.Time(t)
 		},
+		"humanizeBytes": func(n int64) string {
+			return humanize.Bytes(uint64(n))
+		},
 		"s_ify": func(str string, n int) string {
 			if n == 1 {
 				return fmt.Sprintf("%d %s", n, str)
@@ -93,33 +104,69 @@ func main() {
 			return route.RepoPath(r)
 		},
 		"tree_entry_path": func(r *model.Repo, c *model.Commit, t model.TreeEntry) string {
+			p := path.Join(t.DirPath, t.Name)
+			if t.Name == ".." {
+				p = t.DirPath
+			}
 			if t.Type == git.ObjectBlob {
-				return path.Join(route.RepoPath(r), "blob", t.DirPath, t.Name)
+				return route.BlobPath(r, c, &model.Blob{Path: p})
 			} else if t.Type == git.ObjectTree {
-				if t.DirPath == "" {
-					return "/"
-				} else {
-					if t.Name == ".." { // TODO: simplify
-						return path.Join(route.RepoPath(r), "tree", t.DirPath)
-					} else {
-						return path.Join(route.RepoPath(r), "tree", t.DirPath, t.Name)
-					}
-				}
+				return route.TreePath(r, c, p)
 			}
 			return ""
 		},
 		"commit_path": func(r *model.Repo, c *model.Commit) string {
 			return route.CommitPath(r, c)
 		},
+		"archive_path": func(r *model.Repo, c *model.Commit, dir string, format string) string {
+			return route.ArchivePath(r, c, dir, format)
+		},
+		"todo_path": func(r *model.Repo, c *model.Commit, t model.Todo) string {
+			return route.LinePath(r, c, t.Path, t.Line)
+		},
+		"namespace_path": func(ns string) string {
+			return route.NamespacePath(ns)
+		},
+		"commits_path": func(r *model.Repo, e *model.Ref) string {
+			return route.CommitsPath(r, e)
+		},
+		"compare_path": func(r *model.Repo, base string, head string) string {
+			return route.ComparePath(r, base, head)
+		},
+		"search_result_path": func(r model.SearchResult, line int) string {
+			if line == 0 {
+				return route.BlobPath(r.Repo, r.Commit, &model.Blob{Path: r.Path})
+			}
+			return route.LinePath(r.Repo, r.Commit, r.Path, line)
+		},
+		"history_path": func(r *model.Repo, rev string, filepath string) string {
+			return route.HistoryPath(r, rev, filepath)
+		},
+		// the file or directory as of a history entry
+		"history_entry_path": func(r *model.Repo, e model.HistoryEntry, isDir bool) string {
+			if isDir {
+				return route.TreePath(r, e.Commit, e.Path)
+			}
+			return route.BlobPath(r, e.Commit, &model.Blob{Path: e.Path})
+		},
+		"release_path": func(r *model.Repo, t *model.Tag) string {
+			return route.ReleasePath(r, t)
+		},
+		"release_file_path": func(r *model.Repo, t *model.Tag, name string) string {
+			return route.ReleaseFilePath(r, t, name)
+		},
 		"user_path": func(u *model.User) string {
 			return route.UserPath(u)
 		},
 		// TODO: make this less garbage
-		"blob_path": func(r *model.Repo, b *model.Blob) string {
-			return route.BlobPath(r, nil, b)
+		"blob_path": func(r *model.Repo, c *model.Commit, b *model.Blob) string {
+			return route.BlobPath(r, c, b)
 		},
-		"blame_path": func(r *model.Repo, b *model.Blob) string {
-			return route.BlamePath(r, b)
+		"raw_path": func(r *model.Repo, c *model.Commit, b *model.Blob) string {
+			return route.RawPath(r, c, b)
+		},
+		"blame_path": func(r *model.Repo, c *model.Commit, b *model.Blob) string {
+			return route.BlamePath(r, c, b)
 		},
 		"markdown": func(args ...interface{}) template.HTML {
 			// TODO: cache this instead of parsing every time
@@ -135,29 +182,69 @@ func main() {
 		// TODO: figure out how to combine these render funcs
 		"render_blob": func(b *model.Blob) template.HTML {
 			buf := bytes.NewBufferString("<table class=\"diff highlight\">")
+			style := model.HighlightStyle()
 
 			for nu, l := range strings.Split(string(model.HighlightedBlobHTML(b)), "\n") {
-				buf.WriteString("<tr><td class=\"lineno\">" + strconv.Itoa(nu+1) + "</td><td>" + l + "</td></tr>")
+				buf.WriteString("<tr id=\"L" + strconv.Itoa(nu+1) + "\"><td class=\"lineno\">" + strconv.Itoa(nu+1) + "</td><td style=\"" + style + "\">" + l + "</td></tr>")
 			}
 
 			buf.WriteString("</table>")
 			return template.HTML(buf.String())
 		},
-		"render_blame": func(b *model.Blame) template.HTML {
-			buf := bytes.NewBufferString("<table class=\"diff highlight\">")
+		"render_blame": func(r *model.Repo, b *model.Blame) template.HTML {
+			buf := bytes.NewBufferString("<table class=\"diff highlight blame\">")
 
-			for i, u := range b.Users {
-				buf.WriteString("<tr><td><a href=\"" + route.UserPath(u) + "\">" + u.Name + "</a></td><td class=\"lineno\">" + strconv.Itoa(i+1) + "</td><td>" + string(b.Data[i]) + "</td></tr>")
+			for _, h := range b.Hunks {
+				end := h.Start + h.Lines
+				if end > len(b.Data)+1 {
+					end = len(b.Data) + 1
+				}
+				for i := h.Start; i < end; i++ {
+					n := strconv.Itoa(i)
+					buf.WriteString("<tr id=\"L" + n + "\">")
+					if i == h.Start {
+						buf.WriteString("<td class=\"blame-hunk blame-age-" + strconv.Itoa(b.Age(h)) + "\" rowspan=\"" + strconv.Itoa(end-h.Start) + "\">")
+						buf.WriteString("<a class=\"hash\" href=\"" + route.CommitPath(r, h.Commit) + "\">" + h.Commit.Hash()[:7] + "</a> ")
+						buf.WriteString(template.HTMLEscapeString(h.Commit.Summary()) + "<br>")
+						if h.User != nil {
+							buf.WriteString("<a href=\"" + route.UserPath(h.User) + "\">" + template.HTMLEscapeString(h.Name()) + "</a>")
+						} else {
+							buf.WriteString(template.HTMLEscapeString(h.Name()))
+						}
+						buf.WriteString(" <small>" + humanize.Time(h.Signature.When) + "</small>")
+						if h.Skipped != nil {
+							buf.WriteString(" <a class=\"blame-skipped\" href=\"" + route.CommitPath(r, h.Skipped) + "\" title=\"looked past ignored commit " + h.Skipped.Hash()[:7] + "\">ignored " + h.Skipped.Hash()[:7] + "</a>")
+						}
+						if h.Parent != nil {
+							p := route.BlamePath(r, h.Parent, &model.Blob{Path: h.Path}) + "#L" + strconv.Itoa(h.OrigStart)
+							buf.WriteString(" <a class=\"blame-parent\" href=\"" + p + "\" title=\"blame before this commit\">&#x21b6;</a>")
+						}
+						buf.WriteString("</td>")
+					}
+					buf.WriteString("<td class=\"lineno\">" + n + "</td><td>" + template.HTMLEscapeString(strings.TrimRight(string(b.Data[i-1]), "\r\n")) + "</td></tr>")
+				}
 			}
 
 			buf.WriteString("</table>")
 			return template.HTML(buf.String())
 		},
-		"diff_add": func(l git.DiffLine) bool {
-			return l.Origin == git.DiffLineAddition
+		"diff_class": func(o git.DiffLineType) string {
+			switch o {
+			case git.DiffLineAddition:
+				return "diff-add"
+			case git.DiffLineDeletion:
+				return "diff-del"
+			}
+			return ""
 		},
-		"diff_del": func(l git.DiffLine) bool {
-			return l.Origin == git.DiffLineDeletion
+		"diff_sign": func(o git.DiffLineType) string {
+			switch o {
+			case git.DiffLineAddition:
+				return "+"
+			case git.DiffLineDeletion:
+				return "-"
+			}
+			return ""
 		},
 		"highlight_blobs": func(blobs []*model.Blob) template.HTML {
 			var wg sync.WaitGroup
@@ -179,8 +266,8 @@ func main() {
 		"eqv": func(a interface{}, b interface{}) bool {
 			return a == b
 		},
-		"render_commit_graph": func(repo *model.Repo) template.HTML {
-			return template.HTML(helper.RenderLogTree(repo))
+		"render_graph_row": func(row model.GraphRow) template.HTML {
+			return template.HTML(helper.RenderGraphRow(row))
 		},
 	}
 
@@ -191,17 +278,22 @@ func main() {
 
 	e.Renderer = r
 	e.HTTPErrorHandler = func(e error, c echo.Context) {
-		if c.Request().Header.Get("Content-Type") != "application/json" {
-			// TODO: don't always blame teh user :P
-			c.Render(http.StatusBadRequest, "error", struct {
+		// TODO: don't always blame teh user :P
+		code, msg := http.StatusBadRequest, e.Error()
+		if he, ok := e.(*echo.HTTPError); ok {
+			code, msg = he.Code, fmt.Sprint(he.Message)
+		}
+
+		if c.Request().Header.Get("Content-Type") != "application/json" && !helper.WantsJSON(c) {
+			c.Render(code, "error", struct {
 				Repo  *model.Repo
 				Error string
 			}{
 				nil,
-				e.Error(),
+				msg,
 			})
 		} else {
-			c.JSON(400, struct{ Error string }{e.Error()})
+			c.JSON(code, struct{ Error string }{msg})
 		}
 	}
 
@@ -0,0 +1,159 @@
+package main
+
+// embedded ssh server for git push/pull, authenticating against the
+// authentication subkeys in the public keyring
+
+import (
+	"github.com/charles-l/gitamite"
+	"github.com/charles-l/gitamite/server/helper"
+	"github.com/charles-l/gitamite/server/model"
+
+	"golang.org/x/crypto/ssh"
+
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+func serveSSH(repos *model.RepoRegistry, search *model.SearchIndex) {
+	addr, ok := helper.SSHAddr()
+	if !ok {
+		log.Printf("no ssh host key, not starting ssh server")
+		return
+	}
+	keyPath, _ := gitamite.GetConfigValue("ssh_host_key_path")
+
+	hostKey, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		log.Printf("failed to read ssh host key: %s", err)
+		return
+	}
+	signer, err := ssh.ParsePrivateKey(hostKey)
+	if err != nil {
+		log.Printf("invalid ssh host key: %s", err)
+		return
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			u := model.UserFromSSHKey(key)
+			if u == nil {
+				return nil, fmt.Errorf("unknown key %s", ssh.FingerprintSHA256(key))
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"email": u.Email}}, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("failed to start ssh server: %s", err)
+		return
+	}
+	log.Printf("ssh server listening on %s", addr)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Printf("ssh accept: %s", err)
+			continue
+		}
+		go handleSSHConn(conn, config, repos, search)
+	}
+}
+
+func handleSSHConn(conn net.Conn, config *ssh.ServerConfig, repos *model.RepoRegistry, search *model.SearchIndex) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		log.Printf("ssh handshake: %s", err)
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			log.Printf("ssh channel: %s", err)
+			continue
+		}
+		go handleSSHSession(ch, chReqs, sconn.Permissions.Extensions["email"], repos, search)
+	}
+}
+
+// parses `git-upload-pack '/repos/NAME'` (or any of the variations git
+// sends depending on the remote url) into the service and repo name
+func parseGitCommand(cmd string) (string, string, error) {
+	s := strings.SplitN(cmd, " ", 2)
+	if len(s) != 2 || (s[0] != "git-upload-pack" && s[0] != "git-receive-pack") {
+		return "", "", fmt.Errorf("unsupported command '%s'", cmd)
+	}
+	name := strings.Trim(s[1], "'\"")
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "/"), "repos/")
+	return s[0], strings.TrimSuffix(name, ".git"), nil
+}
+
+func canAccess(repo *model.Repo, service string, email string) bool {
+	u := &model.User{Email: email}
+	if service == "git-receive-pack" {
+		return repo.ACL().CanWrite(u)
+	}
+	return repo.ACL().CanRead(u)
+}
+
+func handleSSHSession(ch ssh.Channel, reqs <-chan *ssh.Request, email string, repos *model.RepoRegistry, search *model.SearchIndex) {
+	defer ch.Close()
+
+	for req := range reqs {
+		if req.Type != "exec" || len(req.Payload) < 4 {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		status := uint32(1)
+		service, name, err := parseGitCommand(string(req.Payload[4:]))
+		repo := repos.Get(name)
+		if err != nil {
+			fmt.Fprintf(ch.Stderr(), "gitamite: %s\n", err)
+		} else if repo == nil {
+			fmt.Fprintf(ch.Stderr(), "gitamite: no such repo %s\n", name)
+		} else if !canAccess(repo, service, email) {
+			fmt.Fprintf(ch.Stderr(), "gitamite: %s doesn't have access to %s\n", email, name)
+		} else {
+			log.Printf("ssh: %s %s by %s", service, name, email)
+			cmd := exec.Command("git", strings.TrimPrefix(service, "git-"), repo.Filepath)
+			cmd.Stdin = ch
+			cmd.Stdout = ch
+			cmd.Stderr = ch.Stderr()
+
+			var before map[string]string
+			if service == "git-receive-pack" {
+				before = repo.RefTargets()
+			}
+			if err := cmd.Run(); err != nil {
+				log.Printf("ssh: %s on %s: %s", service, name, err)
+			} else {
+				status = 0
+			}
+			if before != nil {
+				repo.RecordPushes(before, repo.RefTargets(), email)
+				repo.AdoptDefaultBranch()
+				search.Queue(repo)
+			}
+		}
+
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, status)
+		ch.SendRequest("exit-status", false, b)
+		return
+	}
+}
@@ -7,5 +7,6 @@ import (
 
 type Context struct {
 	echo.Context
-	Repos map[string]*model.Repo
+	Repos  *model.RepoRegistry
+	Search *model.SearchIndex
 }
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"github.com/charles-l/gitamite/server/helper"
+
+	"github.com/labstack/echo"
+	"github.com/libgit2/git2go"
+
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+var archiveTypes = map[string]string{
+	"tar.gz": "application/gzip",
+	"zip":    "application/zip",
+}
+
+func Archive(c echo.Context) error {
+	repo, err := helper.RepoParam(c)
+	if err != nil {
+		return err
+	}
+
+	commit, rev, dir, format, err := helper.ArchiveParam(c)
+	if err != nil {
+		return err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	// just the last part of namespaced names, like git clone would use
+	prefix := path.Base(repo.Name) + "-" + strings.Replace(rev, "/", "-", -1)
+	if dir != "" {
+		e, err := tree.EntryByPath(dir)
+		if err != nil || e.Type != git.ObjectTree {
+			return fmt.Errorf("no such directory %s", dir)
+		}
+		if tree, err = repo.LookupTree(e.Id); err != nil {
+			return err
+		}
+		prefix += "-" + path.Base(dir)
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, archiveTypes[format])
+	w.Header().Set(echo.HeaderContentDisposition, "attachment; filename=\""+prefix+"."+format+"\"")
+	w.WriteHeader(http.StatusOK)
+
+	if err := repo.Archive(w, tree, prefix, format); err != nil {
+		// too late to send an error page
+		log.Printf("archive %s of %s: %s", rev, repo.Name, err)
+	}
+	return nil
+}
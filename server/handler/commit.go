@@ -2,56 +2,85 @@ package handler
 
 import (
 	"net/http"
+	"net/url"
 
+	"github.com/charles-l/gitamite/server/api"
 	"github.com/charles-l/gitamite/server/helper"
 	"github.com/charles-l/gitamite/server/model"
 	"github.com/labstack/echo"
 )
 
-func FullCommits(c echo.Context) error {
-	repo, err := helper.RepoParam(c)
+// the same page with the cursor moved on, keeping the filters
+func nextPageURL(c echo.Context, after string) string {
+	q := url.Values{}
+	for k, v := range c.QueryParams() {
+		q[k] = v
+	}
+	q.Set("after", after)
+	return requestPath(c) + "?" + q.Encode()
+}
+
+func renderLog(c echo.Context, repo *model.Repo, ref *model.Ref) error {
+	o, err := helper.LogOptionsParam(c)
 	if err != nil {
 		return err
 	}
+	// the page has the graph, which needs the merged branches too
+	o.AllParents = !helper.WantsJSON(c)
 
-	log := repo.CommitLog(nil)
+	log, next, err := repo.Log(ref, o)
+	if err == model.ErrBadCursor {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else if err != nil {
+		return err
+	}
+
+	nextURL := ""
+	if next != "" {
+		nextURL = nextPageURL(c, next)
+		c.Response().Header().Add("Link", "<"+nextURL+">; rel=\"next\"")
+	}
+
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeLog(log, next))
+	}
 
 	c.Render(http.StatusOK, "log",
 		struct {
 			Repo    *model.Repo
 			Commits []*model.Commit
+			Graph   []model.GraphRow
+			Filter  model.LogOptions
+			Next    string
 		}{
 			repo,
 			log,
+			model.LogGraph(log, repo.RefLabels(), o.AllParents && !o.Filtered()),
+			o,
+			nextURL,
 		})
 	return nil
 }
 
-func Commits(c echo.Context) error {
+func FullCommits(c echo.Context) error {
 	repo, err := helper.RepoParam(c)
 	if err != nil {
 		return err
 	}
 
-	ref, err := helper.RefParam(c, true)
+	return renderLog(c, repo, nil)
+}
+
+func Commits(c echo.Context) error {
+	repo, err := helper.RepoParam(c)
 	if err != nil {
 		return err
 	}
 
-	var log []*model.Commit
-	if ref == nil { // i'm just being explicit
-		log = repo.CommitLog(nil)
-	} else {
-		log = repo.CommitLog(ref)
+	ref, err := helper.RefParam(c, true)
+	if err != nil {
+		return err
 	}
 
-	c.Render(http.StatusOK, "log",
-		struct {
-			Repo    *model.Repo
-			Commits []*model.Commit
-		}{
-			repo,
-			log,
-		})
-	return nil
+	return renderLog(c, repo, ref)
 }
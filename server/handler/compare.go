@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"github.com/charles-l/gitamite/server/api"
+	"github.com/charles-l/gitamite/server/helper"
+	"github.com/charles-l/gitamite/server/model"
+
+	"github.com/labstack/echo"
+
+	"net/http"
+	"strings"
+)
+
+// Compare shows what BASE...HEAD (branches, tags or hashes) would bring into
+// BASE. A lone HEAD is compared to the default branch
+func Compare(c echo.Context) error {
+	repo, err := helper.RepoParam(c)
+	if err != nil {
+		return err
+	}
+
+	spec, format := patchSuffix(strings.TrimPrefix(helper.UnescapedParam(c, "*"), "/"))
+	baseRev, headRev := "HEAD", spec
+	if i := strings.Index(spec, "..."); i >= 0 {
+		baseRev, headRev = spec[:i], spec[i+len("..."):]
+	}
+	if baseRev == "" || headRev == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "compare needs BASE...HEAD")
+	}
+
+	base, err := repo.ResolveCommit(baseRev)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "no such revision "+baseRev)
+	}
+	head, err := repo.ResolveCommit(headRev)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "no such revision "+headRev)
+	}
+
+	o, err := helper.DiffOptionsParam(c)
+	if err != nil {
+		return err
+	}
+	cmp, err := repo.Compare(base, head, o)
+	if err != nil {
+		return err
+	}
+
+	if format == "patch" && cmp.Ahead > len(cmp.Commits) {
+		return echo.NewHTTPError(http.StatusBadRequest, "too many commits for one patch")
+	}
+	if format != "" {
+		return writePatch(c, repo, format, strings.Replace(baseRev+"..."+headRev, "/", "-", -1), cmp.Commits, &cmp.Diff)
+	}
+
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeComparison(cmp))
+	}
+
+	c.Render(http.StatusOK, "compare", struct {
+		Repo       *model.Repo
+		BaseRev    string
+		HeadRev    string
+		Comparison *model.Comparison
+		Diff       *model.Diff
+		Options    model.DiffOptions
+		Mode       string
+	}{
+		repo,
+		baseRev,
+		headRev,
+		cmp,
+		&cmp.Diff,
+		o,
+		helper.DiffModeParam(c),
+	})
+	return nil
+}
@@ -1,14 +1,52 @@
 package handler
 
 import (
+	"github.com/charles-l/gitamite/server/api"
 	"github.com/charles-l/gitamite/server/helper"
 	"github.com/charles-l/gitamite/server/model"
 
 	"github.com/labstack/echo"
 
+	"log"
 	"net/http"
+	"strings"
 )
 
+var patchFormats = []string{"patch", "diff"}
+
+// splits NAME.patch or NAME.diff, for urls that can be downloaded as patches
+func patchSuffix(s string) (string, string) {
+	for _, f := range patchFormats {
+		if strings.HasSuffix(s, "."+f) {
+			return strings.TrimSuffix(s, "."+f), f
+		}
+	}
+	return s, ""
+}
+
+// writePatch sends commits (newest first) as an mbox git am can apply, or
+// diff as a plain diff for git apply
+func writePatch(c echo.Context, repo *model.Repo, format string, name string, commits []*model.Commit, diff *model.Diff) error {
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/plain; charset=utf-8")
+	w.Header().Set(echo.HeaderContentDisposition, "inline; filename=\""+name+"."+format+"\"")
+
+	if format == "diff" {
+		unified, err := diff.Unified()
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, unified)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := repo.FormatPatch(w, model.Oldest(commits)); err != nil {
+		// too late for an error page
+		log.Printf("format-patch %s: %s", repo.Name, err)
+	}
+	return nil
+}
+
 // TODO: clean this up
 func Diff(c echo.Context) error {
 	repo, err := helper.RepoParam(c)
@@ -16,7 +54,8 @@ func Diff(c echo.Context) error {
 		return err
 	}
 
-	commitA, err := repo.LookupCommit(c.Param("oidA"))
+	oid, format := patchSuffix(c.Param("oidA"))
+	commitA, err := repo.LookupCommit(oid)
 	if err != nil {
 		return err
 	}
@@ -35,14 +74,30 @@ func Diff(c echo.Context) error {
 		}
 	}
 
-	diff := model.GetDiff(repo, commitA, commitB)
+	o, err := helper.DiffOptionsParam(c)
+	if err != nil {
+		return err
+	}
+	diff := model.GetDiff(repo, commitA, commitB, o)
+
+	if format != "" {
+		return writePatch(c, repo, format, commitA.Hash(), []*model.Commit{commitA}, &diff)
+	}
+
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeDiff(&diff))
+	}
 
 	c.Render(http.StatusOK, "diff", struct {
-		Repo *model.Repo
-		Diff *model.Diff
+		Repo    *model.Repo
+		Diff    *model.Diff
+		Options model.DiffOptions
+		Mode    string
 	}{
 		repo,
 		&diff,
+		o,
+		helper.DiffModeParam(c),
 	})
 	return nil
 }
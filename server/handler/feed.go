@@ -0,0 +1,256 @@
+package handler
+
+// atom feeds (RFC 4287) of commits, tags and pushes
+
+import (
+	"github.com/charles-l/gitamite/server/context"
+	"github.com/charles-l/gitamite/server/helper"
+	"github.com/charles-l/gitamite/server/model"
+
+	"github.com/labstack/echo"
+	"github.com/libgit2/git2go"
+
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const feedLength = 50
+
+// FeedLinks builds the paths feed entries link to. The route package
+// passes its path funcs in, since handlers can't import it
+type FeedLinks struct {
+	Repo   func(*model.Repo) string
+	Commit func(*model.Repo, *model.Commit) string
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Author  atomPerson `xml:"author"`
+	Link    atomLink   `xml:"link"`
+	Content *atomText  `xml:"content,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ids are the absolute urls of what the entries link to, which never
+// change since they're all addressed by hash
+func baseURL(c echo.Context) string {
+	return c.Scheme() + "://" + c.Request().Host
+}
+
+// the path as requested, since URL.Path has had namespaces escaped
+func requestPath(c echo.Context) string {
+	return strings.SplitN(c.Request().RequestURI, "?", 2)[0]
+}
+
+func newFeed(c echo.Context, title string, alternate string) *atomFeed {
+	base := baseURL(c)
+	return &atomFeed{
+		Title:  title,
+		ID:     base + requestPath(c),
+		Author: atomPerson{Name: "gitamite"},
+		Links: []atomLink{
+			{base + requestPath(c), "self", "application/atom+xml"},
+			{base + alternate, "alternate", "text/html"},
+		},
+	}
+}
+
+func renderFeed(c echo.Context, f *atomFeed) error {
+	// the feed is as new as its newest entry
+	for _, e := range f.Entries {
+		if e.Updated > f.Updated {
+			f.Updated = e.Updated
+		}
+	}
+	if f.Updated == "" {
+		f.Updated = atomTime(time.Now())
+	}
+
+	out, err := xml.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), out...))
+}
+
+func commitEntry(c echo.Context, links FeedLinks, repo *model.Repo, commit *model.Commit) atomEntry {
+	u := baseURL(c) + links.Commit(repo, commit)
+	a := commit.Author()
+	return atomEntry{
+		Title:   commit.Summary(),
+		ID:      u,
+		Updated: atomTime(commit.Committer().When),
+		Author:  atomPerson{a.Name, a.Email},
+		Link:    atomLink{Href: u},
+		Content: &atomText{"text", commit.Message()},
+	}
+}
+
+// CommitFeed is the log of a repo, or of a branch if there's a :ref
+func CommitFeed(links FeedLinks) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		repo, err := helper.RepoParam(c)
+		if err != nil {
+			return err
+		}
+
+		ref, err := helper.RefParam(c, true)
+		if err != nil {
+			return err
+		}
+
+		title := repo.Name + " commits"
+		if ref != nil {
+			title = repo.Name + " commits on " + ref.NiceName()
+		}
+		f := newFeed(c, title, strings.TrimSuffix(requestPath(c), ".atom"))
+
+		log, _, err := repo.Log(ref, model.LogOptions{Limit: feedLength})
+		if err != nil {
+			return err
+		}
+		for _, commit := range log {
+			f.Entries = append(f.Entries, commitEntry(c, links, repo, commit))
+		}
+		return renderFeed(c, f)
+	}
+}
+
+// TagFeed has an entry per tag, linking to the tagged commit
+func TagFeed(links FeedLinks) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		repo, err := helper.RepoParam(c)
+		if err != nil {
+			return err
+		}
+
+		f := newFeed(c, repo.Name+" tags", links.Repo(repo)+"/refs")
+
+		tags := repo.Tags()
+		if len(tags) > feedLength {
+			tags = tags[:feedLength]
+		}
+		for _, t := range tags {
+			e := commitEntry(c, links, repo, t.Commit)
+			e.Title = t.NiceName()
+			e.ID += "#" + t.NiceName()
+			if t.Annotation != nil {
+				tagger := t.Annotation.Tagger()
+				e.Updated = atomTime(tagger.When)
+				e.Author = atomPerson{tagger.Name, tagger.Email}
+				e.Content = &atomText{"text", t.Message()}
+			}
+			f.Entries = append(f.Entries, e)
+		}
+		return renderFeed(c, f)
+	}
+}
+
+func pushTitle(p model.Push) string {
+	ref := strings.TrimPrefix(strings.TrimPrefix(p.Ref, "refs/heads/"), "refs/")
+	switch {
+	case p.Old == "":
+		return p.Pusher + " created " + ref + " in " + p.Repo
+	case p.New == "":
+		return p.Pusher + " deleted " + ref + " in " + p.Repo
+	}
+	return p.Pusher + " pushed to " + ref + " in " + p.Repo
+}
+
+// PushFeed is every recent push to a repo the requester can read
+func PushFeed(links FeedLinks) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		repos := c.(*context.Context).Repos
+		u := helper.RequestUser(c, "browse", nil)
+
+		pushes := model.RecentPushes(feedLength, func(p model.Push) bool {
+			repo := repos.Get(p.Repo)
+			return repo != nil && repo.ACL().CanRead(u)
+		})
+
+		f := newFeed(c, "gitamite pushes", "/")
+		for _, p := range pushes {
+			repo := repos.Get(p.Repo)
+			if repo == nil {
+				// removed since
+				continue
+			}
+
+			e := atomEntry{
+				Title:   pushTitle(p),
+				ID:      baseURL(c) + links.Repo(repo) + "#push-" + p.Ref + "-" + p.Time.UTC().Format(time.RFC3339Nano),
+				Updated: atomTime(p.Time),
+				Author:  atomPerson{p.Pusher, p.Pusher},
+				Link:    atomLink{Href: baseURL(c) + links.Repo(repo)},
+			}
+			if pusher := model.UserFromEmail(p.Pusher); pusher != nil {
+				e.Author.Name = strings.TrimSpace(pusher.Name)
+			}
+
+			if p.New != "" {
+				if commit, err := repo.LookupCommit(p.New); err == nil {
+					e.Link.Href = baseURL(c) + links.Commit(repo, commit)
+					e.Content = &atomText{"text", pushSummary(repo, p)}
+				}
+			}
+			f.Entries = append(f.Entries, e)
+		}
+		return renderFeed(c, f)
+	}
+}
+
+// lists the commits a push added, first parent only
+func pushSummary(repo *model.Repo, p model.Push) string {
+	w, err := repo.Walk()
+	if err != nil {
+		return ""
+	}
+	defer w.Free()
+
+	head, _ := git.NewOid(p.New)
+	w.Push(head)
+	if old, err := git.NewOid(p.Old); err == nil {
+		w.Hide(old)
+	}
+	w.SimplifyFirstParent()
+
+	var lines []string
+	w.Iterate(func(commit *git.Commit) bool {
+		lines = append(lines, commit.Id().String()[:7]+" "+commit.Summary())
+		return len(lines) < feedLength
+	})
+	return strings.Join(lines, "\n")
+}
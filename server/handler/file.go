@@ -1,15 +1,20 @@
 package handler
 
 import (
+	"github.com/charles-l/gitamite/server/api"
 	"github.com/charles-l/gitamite/server/helper"
 	"github.com/charles-l/gitamite/server/model"
 
 	"github.com/labstack/echo"
 
+	"bytes"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
 	"path"
+	"strings"
+	"unicode/utf8"
 )
 
 func File(c echo.Context) error {
@@ -28,23 +33,73 @@ func File(c echo.Context) error {
 		return err
 	}
 
-	ext := path.Ext(helper.PathParam(c))
-	if ext != "" {
-		ext = ext[1:]
-	} else {
-		ext = "text"
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeBlob(s))
 	}
 
 	c.Render(http.StatusOK, "file", struct {
-		Repo *model.Repo
-		Blob *model.Blob
+		Repo   *model.Repo
+		Commit *model.Commit
+		Rev    string
+		Blob   *model.Blob
 	}{
 		repo,
+		helper.LinkCommit(c, commit),
+		helper.RevParam(c),
 		s,
 	})
 	return nil
 }
 
+// types that are safe to show inline. anything else that's text is sent as
+// text/plain, so html and svg files can't run scripts on the site
+var rawInlineTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "audio/", "video/"}
+
+func rawContentType(filepath string, data []byte) (string, bool) {
+	ct := mime.TypeByExtension(path.Ext(filepath))
+	if ct == "" {
+		ct = http.DetectContentType(data)
+	}
+	for _, t := range rawInlineTypes {
+		if strings.HasPrefix(ct, t) {
+			return ct, true
+		}
+	}
+	if utf8.Valid(data) && bytes.IndexByte(data, 0) < 0 {
+		return "text/plain; charset=utf-8", true
+	}
+	return "application/octet-stream", false
+}
+
+// Raw sends a file as is
+func Raw(c echo.Context) error {
+	repo, err := helper.RepoParam(c)
+	if err != nil {
+		return err
+	}
+
+	commit, err := helper.CommitParam(c)
+	if err != nil {
+		return err
+	}
+
+	b, err := repo.ReadBlob(commit, helper.PathParam(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	data := b.ByteArray()
+
+	ct, inline := rawContentType(b.Path, data)
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+	}
+	h := c.Response().Header()
+	h.Set(echo.HeaderContentDisposition, disposition+"; filename=\""+path.Base(b.Path)+"\"")
+	h.Set("X-Content-Type-Options", "nosniff")
+	return c.Blob(http.StatusOK, ct, data)
+}
+
 func Blame(c echo.Context) error {
 	// TODO: figure out how to pull the repo check out further so it's not duplicated everywhere
 	repo, err := helper.RepoParam(c)
@@ -63,11 +118,19 @@ func Blame(c echo.Context) error {
 		return fmt.Errorf("failed to get blob")
 	}
 
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeBlame(s))
+	}
+
 	c.Render(http.StatusOK, "blame", struct {
-		Repo  *model.Repo
-		Blame *model.Blame
+		Repo   *model.Repo
+		Commit *model.Commit
+		Rev    string
+		Blame  *model.Blame
 	}{
 		repo,
+		helper.LinkCommit(c, commit),
+		helper.RevParam(c),
 		s,
 	})
 	return nil
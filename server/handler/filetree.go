@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"github.com/charles-l/gitamite/server/api"
 	"github.com/charles-l/gitamite/server/helper"
 	"github.com/charles-l/gitamite/server/model"
 
@@ -18,12 +19,31 @@ func FileTree(c echo.Context) error {
 	}
 
 	commit, err := helper.CommitParam(c)
+	if err != nil && (c.Param("commit") != "" || !repo.HeadUnborn()) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
 	if err != nil {
-		// TODO: pass server name
+		// either there's nothing at all, or HEAD points to a branch that
+		// was never pushed
+		branches := repo.Refs()
+		if helper.WantsJSON(c) {
+			if len(branches) > 0 {
+				return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("default branch %s doesn't exist", repo.DefaultBranch()))
+			}
+			return echo.NewHTTPError(http.StatusNotFound, "repo is empty")
+		}
 		c.Render(http.StatusOK, "empty", struct {
-			Repo *model.Repo
+			Repo          *model.Repo
+			Host          string
+			SSHURL        string
+			DefaultBranch string
+			Branches      []*model.Ref
 		}{
 			repo,
+			c.Request().Host,
+			helper.SSHCloneURL(c, repo.Name),
+			repo.DefaultBranch(),
+			branches,
 		})
 		return nil
 	}
@@ -48,13 +68,23 @@ func FileTree(c echo.Context) error {
 		}
 	}
 
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeTree(path, entries))
+	}
+
 	c.Render(http.StatusOK, "filelist",
 		struct {
 			Repo    *model.Repo
+			Commit  *model.Commit
+			Rev     string
+			Path    string
 			Entries []model.TreeEntry
 			README  string
 		}{
 			repo,
+			commit,
+			helper.RevParam(c),
+			path,
 			entries,
 			readme,
 		})
@@ -0,0 +1,181 @@
+package handler
+
+// git smart HTTP transport (see git's Documentation/technical/http-protocol.txt)
+
+import (
+	"github.com/charles-l/gitamite"
+	"github.com/charles-l/gitamite/server/context"
+	"github.com/charles-l/gitamite/server/helper"
+	"github.com/charles-l/gitamite/server/model"
+
+	"github.com/labstack/echo"
+
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var gitServices = map[string]bool{
+	"git-upload-pack":  true,
+	"git-receive-pack": true,
+}
+
+func pktLine(s string) []byte {
+	return []byte(fmt.Sprintf("%04x%s", len(s)+4, s))
+}
+
+func gitCommand(repo *model.Repo, service string, c echo.Context, args ...string) *exec.Cmd {
+	args = append([]string{strings.TrimPrefix(service, "git-"), "--stateless-rpc"}, args...)
+	cmd := exec.Command("git", append(args, repo.Filepath)...)
+	cmd.Env = os.Environ()
+	if p := c.Request().Header.Get("Git-Protocol"); p != "" {
+		cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+p)
+	}
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+func gitRequestUser(c echo.Context, repo *model.Repo) *model.User {
+	_, token, ok := c.Request().BasicAuth()
+	if !ok {
+		return nil
+	}
+
+	// git sends the same credentials for the ref advertisement and (if the
+	// pack is big) an empty probe request before the actual upload, so only
+	// the upload itself uses up the nonce
+	var nonces gitamite.NonceStore
+	if c.Request().Method == http.MethodPost && c.Request().ContentLength != 4 {
+		nonces = model.NonceStore{}
+	}
+
+	a, err := gitamite.ParseAuthToken(token)
+	if err != nil {
+		return nil
+	}
+	e, err := a.VerifyRequest(c.Request().Host, "git", nonces)
+	if err != nil {
+		log.Printf("rejected git request for %s from %s: %s", repo.Name, c.RealIP(), err)
+		return nil
+	}
+
+	d, _ := a.Data.(map[string]interface{})
+	if name, _ := d["Name"].(string); name != repo.Name {
+		return nil
+	}
+	return model.UserFromEntity(e)
+}
+
+// pushes and private repos need a signed AuthRequest for the repo passed as
+// the basic auth password, which `gitamite credential` hands to git
+func authorizeGitRequest(c echo.Context, repo *model.Repo, service string) (*model.User, bool) {
+	acl := repo.ACL()
+	if service == "git-upload-pack" && !acl.Private {
+		return nil, true
+	}
+
+	u := gitRequestUser(c, repo)
+	allowed := acl.CanWrite(u)
+	if service == "git-upload-pack" {
+		allowed = acl.CanRead(u)
+	}
+	if allowed {
+		return u, true
+	}
+
+	if u != nil {
+		c.String(http.StatusForbidden, u.Email+" doesn't have access to "+repo.Name+"\n")
+		return nil, false
+	}
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Basic realm=\"gitamite\"")
+	c.String(http.StatusUnauthorized, "need a signed request for "+repo.Name+"\n")
+	return nil, false
+}
+
+func GitInfoRefs(c echo.Context) error {
+	repo, err := helper.LookupRepo(c)
+	if err != nil {
+		return err
+	}
+
+	service := c.QueryParam("service")
+	if !gitServices[service] {
+		return fmt.Errorf("only the smart http protocol is supported")
+	}
+
+	if _, ok := authorizeGitRequest(c, repo, service); !ok {
+		return nil
+	}
+
+	refs, err := gitCommand(repo, service, c, "--advertise-refs").Output()
+	if err != nil {
+		log.Printf("%s: %s", service, err)
+		return fmt.Errorf("failed to read refs")
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "application/x-"+service+"-advertisement")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Write(pktLine("# service=" + service + "\n"))
+	w.Write([]byte("0000"))
+	w.Write(refs)
+	return nil
+}
+
+func serveGitRPC(c echo.Context, service string) error {
+	repo, err := helper.LookupRepo(c)
+	if err != nil {
+		return err
+	}
+
+	u, ok := authorizeGitRequest(c, repo, service)
+	if !ok {
+		return nil
+	}
+
+	var body io.Reader = c.Request().Body
+	if c.Request().Header.Get("Content-Encoding") == "gzip" {
+		if body, err = gzip.NewReader(body); err != nil {
+			return err
+		}
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "application/x-"+service+"-result")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var before map[string]string
+	if service == "git-receive-pack" {
+		before = repo.RefTargets()
+	}
+
+	cmd := gitCommand(repo, service, c)
+	cmd.Stdin = body
+	cmd.Stdout = w
+	if err := cmd.Run(); err != nil {
+		// the status line has already been sent, so all we can do is log it
+		log.Printf("%s on %s: %s", service, repo.Name, err)
+	}
+
+	if before != nil && u != nil {
+		repo.RecordPushes(before, repo.RefTargets(), u.Email)
+		repo.AdoptDefaultBranch()
+		c.(*context.Context).Search.Queue(repo)
+	}
+	return nil
+}
+
+func GitUploadPack(c echo.Context) error {
+	return serveGitRPC(c, "git-upload-pack")
+}
+
+func GitReceivePack(c echo.Context) error {
+	return serveGitRPC(c, "git-receive-pack")
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"github.com/charles-l/gitamite/server/api"
+	"github.com/charles-l/gitamite/server/helper"
+	"github.com/charles-l/gitamite/server/model"
+
+	"github.com/labstack/echo"
+	"github.com/libgit2/git2go"
+
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// History lists the commits that changed a file or directory
+func History(c echo.Context) error {
+	repo, err := helper.RepoParam(c)
+	if err != nil {
+		return err
+	}
+
+	rev := helper.RevParam(c)
+	commit, err := repo.ResolveCommit(rev)
+	if err != nil {
+		return err
+	}
+
+	p := strings.TrimPrefix(helper.PathParam(c), "/")
+	isDir := p == ""
+	if !isDir {
+		t, _ := commit.Tree()
+		e, err := t.EntryByPath(p)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "no such file "+p)
+		}
+		isDir = e.Type == git.ObjectTree
+	}
+
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	entries, next, err := repo.History(commit, p, c.QueryParam("after"), limit)
+	if err == model.ErrBadCursor {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else if err != nil {
+		return err
+	}
+
+	nextURL := ""
+	if next != "" {
+		nextURL = nextPageURL(c, next)
+		c.Response().Header().Add("Link", "<"+nextURL+">; rel=\"next\"")
+	}
+
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeHistory(entries, next))
+	}
+
+	c.Render(http.StatusOK, "history", struct {
+		Repo    *model.Repo
+		Rev     string
+		Path    string
+		IsDir   bool
+		Entries []model.HistoryEntry
+		Next    string
+	}{
+		repo,
+		rev,
+		p,
+		isDir,
+		entries,
+		nextURL,
+	})
+	return nil
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"github.com/charles-l/gitamite/server/api"
 	"github.com/charles-l/gitamite/server/helper"
 	"github.com/charles-l/gitamite/server/model"
 
@@ -16,13 +17,36 @@ func Refs(c echo.Context) error {
 	}
 
 	refs := repo.Refs()
+	tags := repo.Tags()
+
+	// how far each branch is from the default one
+	divergence := make(map[string]model.Divergence)
+	defaultBranch := ""
+	if head, err := repo.Head(); err == nil {
+		defaultBranch = head.Shorthand()
+		for _, r := range refs {
+			if d, err := repo.Diverged(r.Target(), head.Target()); err == nil {
+				divergence[r.Name()] = d
+			}
+		}
+	}
+
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeRefs(refs, tags, defaultBranch, divergence))
+	}
 
 	c.Render(http.StatusOK, "refs", struct {
-		Repo *model.Repo
-		Refs []*model.Ref
+		Repo          *model.Repo
+		Refs          []*model.Ref
+		Tags          []*model.Tag
+		DefaultBranch string
+		Divergence    map[string]model.Divergence
 	}{
 		repo,
 		refs,
+		tags,
+		defaultBranch,
+		divergence,
 	})
 	return nil
 }
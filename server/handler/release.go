@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"github.com/charles-l/gitamite/server/api"
+	"github.com/charles-l/gitamite/server/context"
+	"github.com/charles-l/gitamite/server/helper"
+	"github.com/charles-l/gitamite/server/model"
+
+	"github.com/labstack/echo"
+
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+)
+
+func Tags(c echo.Context) error {
+	repo, err := helper.RepoParam(c)
+	if err != nil {
+		return err
+	}
+
+	tags := repo.Tags()
+	releases := make(map[string]bool)
+	for _, t := range tags {
+		releases[t.Name] = repo.Release(t) != nil
+	}
+
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeTags(tags, releases))
+	}
+
+	c.Render(http.StatusOK, "tags", struct {
+		Repo     *model.Repo
+		Tags     []*model.Tag
+		Releases map[string]bool
+	}{
+		repo,
+		tags,
+		releases,
+	})
+	return nil
+}
+
+func renderReleases(c echo.Context, repo *model.Repo, releases []*model.Release, single bool) error {
+	c.Render(http.StatusOK, "releases", struct {
+		Repo     *model.Repo
+		Releases []*model.Release
+		Single   bool
+	}{
+		repo,
+		releases,
+		single,
+	})
+	return nil
+}
+
+func Releases(c echo.Context) error {
+	repo, err := helper.RepoParam(c)
+	if err != nil {
+		return err
+	}
+
+	releases := repo.Releases()
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeReleases(releases))
+	}
+	return renderReleases(c, repo, releases, false)
+}
+
+func Release(c echo.Context) error {
+	repo, err := helper.RepoParam(c)
+	if err != nil {
+		return err
+	}
+
+	t, err := helper.TagParam(c)
+	if err != nil {
+		return err
+	}
+
+	rel := repo.Release(t)
+	if rel == nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("%s isn't a release", t.ShortName()))
+	}
+
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeRelease(rel))
+	}
+	return renderReleases(c, repo, []*model.Release{rel}, true)
+}
+
+// ReleaseFile downloads a file attached to a release
+func ReleaseFile(c echo.Context) error {
+	repo, err := helper.RepoParam(c)
+	if err != nil {
+		return err
+	}
+
+	t, err := helper.TagParam(c)
+	if err != nil {
+		return err
+	}
+
+	p, err := repo.ReleaseFilePath(t, helper.UnescapedParam(c, "file"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	return c.Attachment(p, path.Base(p))
+}
+
+// releaseRequest reads a signed request about a release (Name is the repo,
+// Tag the tag) from someone who can push to the repo
+func releaseRequest(c echo.Context) (map[string]interface{}, *model.Repo, *model.Tag, *model.User, error) {
+	a, u, err := readAuthJSONRequest(c)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	d, _ := a.Data.(map[string]interface{})
+	name, _ := d["Name"].(string)
+	repo := c.(*context.Context).Repos.Get(path.Clean(name))
+	if repo == nil {
+		return nil, nil, nil, nil, fmt.Errorf("repo doesn't exist")
+	}
+	if !repo.ACL().CanWrite(u) {
+		return nil, nil, nil, nil, fmt.Errorf("you can't change releases of %s", repo.Name)
+	}
+
+	tag, _ := d["Tag"].(string)
+	t, err := repo.LookupTag(tag)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return d, repo, t, u, nil
+}
+
+// UpdateRelease makes a tag into a release, or changes its Notes
+func UpdateRelease(c echo.Context) error {
+	d, repo, t, u, err := releaseRequest(c)
+	if err != nil {
+		return err
+	}
+
+	notes, _ := d["Notes"].(string)
+	log.Printf("%s updated release %s of %s", u.Email, t.ShortName(), repo.Name)
+	return repo.SaveRelease(t, notes)
+}
+
+// UploadReleaseFile attaches File to a tag's release. Content is the file,
+// base64'd
+func UploadReleaseFile(c echo.Context) error {
+	// base64 makes it a third bigger, plus there's the rest of the request
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, model.MaxReleaseFileSize/3*4+1<<20)
+
+	d, repo, t, u, err := releaseRequest(c)
+	if err != nil {
+		return err
+	}
+
+	file, _ := d["File"].(string)
+	content, _ := d["Content"].(string)
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return fmt.Errorf("file content needs to be base64")
+	}
+
+	log.Printf("%s uploaded %s to release %s of %s", u.Email, file, t.ShortName(), repo.Name)
+	return repo.AddReleaseFile(t, file, data)
+}
+
+// DeleteRelease removes File from a release, or the whole release (but not
+// the tag) if there's no File
+func DeleteRelease(c echo.Context) error {
+	d, repo, t, u, err := releaseRequest(c)
+	if err != nil {
+		return err
+	}
+
+	if file, _ := d["File"].(string); file != "" {
+		log.Printf("%s deleted %s from release %s of %s", u.Email, file, t.ShortName(), repo.Name)
+		return repo.DeleteReleaseFile(t, file)
+	}
+	log.Printf("%s deleted release %s of %s", u.Email, t.ShortName(), repo.Name)
+	return repo.DeleteRelease(t)
+}
@@ -2,7 +2,9 @@ package handler
 
 import (
 	"github.com/charles-l/gitamite"
+	"github.com/charles-l/gitamite/server/api"
 	"github.com/charles-l/gitamite/server/context"
+	"github.com/charles-l/gitamite/server/helper"
 	"github.com/charles-l/gitamite/server/model"
 	"github.com/libgit2/git2go"
 
@@ -13,31 +15,41 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"strings"
 )
 
 // TODO: Move to library
-func readAuthJSONRequest(c echo.Context) (*gitamite.AuthRequest, error) {
+func readAuthJSONRequest(c echo.Context) (*gitamite.AuthRequest, *model.User, error) {
 	blob, err := ioutil.ReadAll(c.Request().Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var a gitamite.AuthRequest
 	err = json.Unmarshal(blob, &a)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if len(a.Signature) == 0 || a.Data == nil {
-		return nil, fmt.Errorf("Need data and signature")
+		return nil, nil, fmt.Errorf("Need data and signature")
 	}
 
-	if err := a.VerifyRequest(); err != nil {
-		return nil, fmt.Errorf("Invalid signature")
+	signer, err := a.VerifyRequest(c.Request().Host, c.Request().Method, model.NonceStore{})
+	if err != nil {
+		log.Printf("rejected request: %s", err)
+		return nil, nil, fmt.Errorf("Invalid request")
+	}
+
+	// a key without any identities can't own or change anything
+	u := model.UserFromEntity(signer)
+	if u == nil {
+		return nil, nil, fmt.Errorf("signing key has no identity")
 	}
-	return &a, nil
+	return &a, u, nil
 }
 
 func exists(filepath string) bool {
@@ -47,8 +59,21 @@ func exists(filepath string) bool {
 	return false
 }
 
+// names are paths under repo_dir, with namespaces as directories
+func validRepoName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") {
+		return false
+	}
+	for _, s := range strings.Split(name, "/") {
+		if s == "" || s == "." || s == ".." {
+			return false
+		}
+	}
+	return true
+}
+
 func DeleteRepo(c echo.Context) error {
-	a, err := readAuthJSONRequest(c)
+	a, u, err := readAuthJSONRequest(c)
 	if err != nil {
 		return err
 	}
@@ -56,7 +81,7 @@ func DeleteRepo(c echo.Context) error {
 	name, ok := a.Data.(map[string]interface{})["Name"].(string)
 	name = path.Clean(name) // sanatize
 
-	if !ok {
+	if !ok || !validRepoName(name) {
 		return fmt.Errorf("need a valid repo name")
 	}
 
@@ -65,6 +90,16 @@ func DeleteRepo(c echo.Context) error {
 		return err
 	}
 
+	// only ever delete a registered repo, never some other path under
+	// repo_dir (like a repo's objects, or a whole namespace)
+	repo := c.(*context.Context).Repos.Get(name)
+	if repo == nil {
+		return fmt.Errorf("repo doesn't exist")
+	}
+	if !repo.ACL().IsOwner(u) {
+		return fmt.Errorf("only the owner can delete %s", name)
+	}
+
 	repoPath := path.Join(p, name)
 	if path.Dir(repoPath) == "/" || repoPath == "/" {
 		log.Fatal("cowardly bailing out 'cause I don't want to accidentally delete something important: " + repoPath)
@@ -75,12 +110,22 @@ func DeleteRepo(c echo.Context) error {
 
 	log.Printf("deleting repo %s", repoPath)
 	os.RemoveAll(repoPath)
-	delete(c.(*context.Context).Repos, name)
+	c.(*context.Context).Repos.Remove(name)
+
+	// clean up namespaces that are empty now
+	for dir := path.Dir(repoPath); dir != path.Clean(p); dir = path.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	model.DeleteACL(name)
+	model.DeleteCommitIndex(name)
+	c.(*context.Context).Search.Remove(name)
 	return nil
 }
 
 func CreateRepo(c echo.Context) error {
-	a, err := readAuthJSONRequest(c)
+	a, u, err := readAuthJSONRequest(c)
 	if err != nil {
 		return err
 	}
@@ -88,7 +133,7 @@ func CreateRepo(c echo.Context) error {
 	name, ok := a.Data.(map[string]interface{})["Name"].(string)
 	name = path.Clean(name) // sanatize
 
-	if !ok {
+	if !ok || !validRepoName(name) {
 		return fmt.Errorf("need a valid repo name")
 	}
 
@@ -101,6 +146,9 @@ func CreateRepo(c echo.Context) error {
 	if exists(newRepoPath) {
 		return fmt.Errorf("repo already exists")
 	}
+	if parent := c.(*context.Context).Repos.Containing(name); parent != nil {
+		return fmt.Errorf("can't put a repo inside %s", parent.Name)
+	}
 
 	log.Printf("creating new repo: %s", newRepoPath)
 
@@ -108,24 +156,161 @@ func CreateRepo(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	c.(*context.Context).Repos[name] = &model.Repo{name, newRepoPath, "", repo}
+	r := &model.Repo{name, newRepoPath, "", repo}
+
+	private, _ := a.Data.(map[string]interface{})["Private"].(bool)
+	if err := r.SetACL(model.ACL{Owner: u.Email, Private: private}); err != nil {
+		return err
+	}
+
+	c.(*context.Context).Repos.Add(r)
 	return nil
 }
 
-func Repos(c echo.Context) error {
-	repos := c.(*context.Context).Repos
+// UpdateRepo changes a repo's visibility, collaborators, default branch and
+// the commits blame looks past. Fields that aren't in the request are left
+// alone
+func UpdateRepo(c echo.Context) error {
+	a, u, err := readAuthJSONRequest(c)
+	if err != nil {
+		return err
+	}
+
+	d, _ := a.Data.(map[string]interface{})
+	name, _ := d["Name"].(string)
+	repo := c.(*context.Context).Repos.Get(path.Clean(name))
+	if repo == nil {
+		return fmt.Errorf("repo doesn't exist")
+	}
+
+	acl := repo.ACL()
+	if !acl.IsOwner(u) {
+		return fmt.Errorf("only the owner can change %s", repo.Name)
+	}
+
+	if private, ok := d["Private"].(bool); ok {
+		acl.Private = private
+	}
+	if collaborators, ok := d["Collaborators"].([]interface{}); ok {
+		acl.Collaborators = nil
+		for _, e := range collaborators {
+			if email, ok := e.(string); ok {
+				acl.Collaborators = append(acl.Collaborators, email)
+			}
+		}
+	}
+
+	if branch, ok := d["DefaultBranch"].(string); ok {
+		if err := repo.SetDefaultBranch(branch); err != nil {
+			return err
+		}
+		log.Printf("%s set the default branch of %s to %s", u.Email, repo.Name, branch)
+	}
+
+	if ignore, ok := d["BlameIgnoreRevs"].([]interface{}); ok {
+		var revs []string
+		for _, e := range ignore {
+			if rev, ok := e.(string); ok {
+				revs = append(revs, rev)
+			}
+		}
+		if err := repo.SetBlameIgnoreRevs(revs); err != nil {
+			return err
+		}
+		log.Printf("%s set the blame ignore-revs of %s to %v", u.Email, repo.Name, revs)
+	}
+
+	log.Printf("%s updated access to %s: %+v", u.Email, repo.Name, acl)
+	return repo.SetACL(acl)
+}
+
+// readable repos in ns (however deep)
+func visibleRepos(c echo.Context, ns string) []*model.Repo {
+	repos := c.(*context.Context).Repos.Under(ns)
+	u := helper.RequestUser(c, "browse", nil)
 
 	vals := make([]*model.Repo, 0, len(repos))
 	for _, v := range repos {
-		vals = append(vals, v)
+		if v.ACL().CanRead(u) {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
+
+// splits repos into the ones directly in ns and the namespaces the rest are
+// in. Namespaces without anything visible in them don't show up
+func namespaceChildren(ns string, repos []*model.Repo) ([]*model.Repo, []string) {
+	prefix := ""
+	if ns != "" {
+		prefix = ns + "/"
+	}
+
+	var children []*model.Repo
+	var namespaces []string
+	for _, r := range repos {
+		rest := strings.TrimPrefix(r.Name, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			child := prefix + rest[:i]
+			if len(namespaces) == 0 || namespaces[len(namespaces)-1] != child {
+				namespaces = append(namespaces, child)
+			}
+		} else {
+			children = append(children, r)
+		}
+	}
+	return children, namespaces
+}
+
+func Repos(c echo.Context) error {
+	vals := visibleRepos(c, "")
+
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeRepos(vals))
+	}
+
+	repos, namespaces := namespaceChildren("", vals)
+	c.Render(http.StatusOK, "repos", struct {
+		Repo       *model.Repo
+		Namespace  string
+		Namespaces []string
+		Repos      []*model.Repo
+	}{
+		nil,
+		"",
+		namespaces,
+		repos,
+	})
+	return nil
+}
+
+// Namespace lists the repos and namespaces in a namespace
+func Namespace(c echo.Context) error {
+	ns, err := url.PathUnescape(c.Param("namespace"))
+	if err != nil {
+		return err
+	}
+
+	vals := visibleRepos(c, ns)
+	if len(vals) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "no such namespace")
+	}
+	repos, namespaces := namespaceChildren(ns, vals)
+
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeNamespace(ns, namespaces, repos))
 	}
 
 	c.Render(http.StatusOK, "repos", struct {
-		Repo  *model.Repo
-		Repos []*model.Repo
+		Repo       *model.Repo
+		Namespace  string
+		Namespaces []string
+		Repos      []*model.Repo
 	}{
 		nil,
-		vals,
+		ns,
+		namespaces,
+		repos,
 	})
 	return nil
 }
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"github.com/charles-l/gitamite/server/api"
+	"github.com/charles-l/gitamite/server/context"
+	"github.com/charles-l/gitamite/server/helper"
+	"github.com/charles-l/gitamite/server/model"
+
+	"github.com/labstack/echo"
+
+	"net/http"
+)
+
+func renderSearch(c echo.Context, repo *model.Repo, repos []*model.Repo) error {
+	q := helper.SearchQueryParam(c)
+
+	var results []model.SearchResult
+	more := false
+	if !q.Empty() {
+		var err error
+		results, more, err = c.(*context.Context).Search.Search(repos, q)
+		if err != nil {
+			return err
+		}
+	}
+
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeSearchResults(results))
+	}
+
+	c.Render(http.StatusOK, "search", struct {
+		Repo    *model.Repo
+		Query   model.SearchQuery
+		Results []model.SearchResult
+		More    bool
+	}{
+		repo,
+		q,
+		results,
+		more,
+	})
+	return nil
+}
+
+// Search looks through the default branch of every repo the user can see
+func Search(c echo.Context) error {
+	return renderSearch(c, nil, visibleRepos(c, ""))
+}
+
+// RepoSearch looks through one repo's default branch
+func RepoSearch(c echo.Context) error {
+	repo, err := helper.RepoParam(c)
+	if err != nil {
+		return err
+	}
+	return renderSearch(c, repo, []*model.Repo{repo})
+}
+
+func renderCommitSearch(c echo.Context, repo *model.Repo, repos []*model.Repo) error {
+	q := c.QueryParam("q")
+
+	var hits []model.CommitHit
+	more := false
+	if q != "" {
+		var err error
+		if hits, more, err = model.SearchCommits(repos, q); err != nil {
+			return err
+		}
+	}
+
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeCommitHits(hits))
+	}
+
+	c.Render(http.StatusOK, "commit_search", struct {
+		Repo  *model.Repo
+		Query string
+		Hits  []model.CommitHit
+		More  bool
+	}{
+		repo,
+		q,
+		hits,
+		more,
+	})
+	return nil
+}
+
+// CommitSearch looks for commits by message, author, path or trailer in
+// every repo the user can see
+func CommitSearch(c echo.Context) error {
+	return renderCommitSearch(c, nil, visibleRepos(c, ""))
+}
+
+// RepoCommitSearch looks for commits in one repo
+func RepoCommitSearch(c echo.Context) error {
+	repo, err := helper.RepoParam(c)
+	if err != nil {
+		return err
+	}
+	return renderCommitSearch(c, repo, []*model.Repo{repo})
+}
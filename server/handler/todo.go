@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"github.com/charles-l/gitamite/server/api"
+	"github.com/charles-l/gitamite/server/helper"
+	"github.com/charles-l/gitamite/server/model"
+
+	"github.com/labstack/echo"
+
+	"fmt"
+	"log"
+	"net/http"
+)
+
+func Todos(c echo.Context) error {
+	repo, err := helper.RepoParam(c)
+	if err != nil {
+		return err
+	}
+
+	commit, err := helper.CommitParam(c)
+	if err != nil {
+		return err
+	}
+
+	todos, err := repo.Todos(commit)
+	if err != nil {
+		log.Printf("todos: %s", err)
+		return fmt.Errorf("failed to collect todos")
+	}
+
+	if helper.WantsJSON(c) {
+		return c.JSON(http.StatusOK, api.MakeTodos(todos))
+	}
+
+	c.Render(http.StatusOK, "todos", struct {
+		Repo   *model.Repo
+		Commit *model.Commit
+		Todos  []model.Todo
+	}{
+		repo,
+		commit,
+		todos,
+	})
+	return nil
+}
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"github.com/charles-l/gitamite/server/api"
+	"github.com/charles-l/gitamite/server/helper"
 	"github.com/charles-l/gitamite/server/model"
 	"github.com/labstack/echo"
 
@@ -18,6 +20,12 @@ func User(c echo.Context) error {
 		return fmt.Errorf("failed to get user: " + email)
 	}
 
+	if helper.WantsJSON(c) {
+		j := api.MakeUser(u)
+		j.PublicKey = model.ArmoredPublicKey(u).String()
+		return c.JSON(http.StatusOK, j)
+	}
+
 	c.String(http.StatusOK, strings.Join([]string{
 		u.Name,
 		u.Email,
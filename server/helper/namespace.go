@@ -0,0 +1,55 @@
+package helper
+
+import (
+	"github.com/charles-l/gitamite/server/model"
+
+	"github.com/labstack/echo"
+
+	"net/url"
+	"strings"
+)
+
+var repoPrefixes = []string{"/repo/", "/api/v1/repo/"}
+
+// RepoNamespaces lets repo names have slashes in them (team/project) even
+// though the routes only take one segment for :repo. It finds the longest
+// part of the path after /repo/ that's a repo and escapes it into a single
+// segment, which LookupRepo unescapes. Paths to a namespace itself go to
+// its index page at /namespace/:namespace
+func RepoNamespaces(repos *model.RepoRegistry) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			u := c.Request().URL
+			if p := rewriteRepoPath(repos, u.Path); p != u.Path {
+				u.Path, u.RawPath = p, ""
+			}
+			return next(c)
+		}
+	}
+}
+
+func rewriteRepoPath(repos *model.RepoRegistry, p string) string {
+	for _, prefix := range repoPrefixes {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		segs := strings.Split(strings.TrimPrefix(p, prefix), "/")
+
+		// single segment names already work
+		for n := len(segs); n > 1; n-- {
+			name := strings.Join(segs[:n], "/")
+			if repos.Get(strings.TrimSuffix(name, ".git")) != nil {
+				rest := strings.Join(segs[n:], "/")
+				if rest != "" {
+					rest = "/" + rest
+				}
+				return prefix + url.PathEscape(name) + rest
+			}
+		}
+
+		if ns := strings.Join(segs, "/"); repos.Get(ns) == nil && repos.IsNamespace(ns) {
+			return strings.TrimSuffix(prefix, "/repo/") + "/namespace/" + url.PathEscape(ns)
+		}
+	}
+	return p
+}
@@ -0,0 +1,53 @@
+package helper
+
+import (
+	"github.com/labstack/echo"
+
+	"strconv"
+	"strings"
+)
+
+// WantsJSON is true for /api requests, or when the Accept header prefers
+// json over html
+func WantsJSON(c echo.Context) bool {
+	if strings.HasPrefix(c.Path(), "/api/") {
+		return true
+	}
+
+	accept := c.Request().Header.Get(echo.HeaderAccept)
+	if accept == "" {
+		return false
+	}
+	return quality(accept, echo.MIMEApplicationJSON) > quality(accept, echo.MIMETextHTML)
+}
+
+// quality of mime in an Accept header, with exact matches beating wildcards
+func quality(accept, mime string) float64 {
+	best, bestSpecificity := 0.0, -1
+	for _, r := range strings.Split(accept, ",") {
+		params := strings.Split(r, ";")
+		t := strings.TrimSpace(params[0])
+
+		specificity := -1
+		switch {
+		case t == mime:
+			specificity = 2
+		case t == strings.SplitN(mime, "/", 2)[0]+"/*":
+			specificity = 1
+		case t == "*/*":
+			specificity = 0
+		}
+		if specificity <= bestSpecificity {
+			continue
+		}
+
+		q := 1.0
+		for _, p := range params[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(p), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				q, _ = strconv.ParseFloat(kv[1], 64)
+			}
+		}
+		best, bestSpecificity = q, specificity
+	}
+	return best
+}
@@ -0,0 +1,77 @@
+package helper
+
+import (
+	"github.com/charles-l/gitamite/server/model"
+
+	"github.com/labstack/echo"
+
+	"net/url"
+	"strings"
+)
+
+// where a ref can go in the path after /repo/NAME/, and what has to come
+// after it for it to be a ref there
+var refPositions = []struct {
+	before string
+	after  func(string) bool
+}{
+	{"", func(s string) bool { return s == "/commits" || s == "/commits.atom" || s == "/todos" }},
+	{"history/", func(s string) bool { return s == "" || strings.HasPrefix(s, "/") }},
+	{"commit/", func(s string) bool { return strings.HasPrefix(s, "/") }},
+	{"archive/", func(s string) bool { return strings.HasPrefix(s, "/") || strings.HasPrefix(s, ".") }},
+}
+
+// RefNames lets branches and tags have slashes in them (feature/login)
+// even though the routes only take one segment for :ref and :commit. Like
+// GitHub's tree/REF/PATH, it finds the longest ref that fits where a ref
+// can go and escapes it into a single segment, which RefParam and
+// CommitParam unescape. It has to come after RepoNamespaces
+func RefNames(repos *model.RepoRegistry) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			u := c.Request().URL
+			if p := rewriteRefPath(repos, u.Path); p != u.Path {
+				u.Path, u.RawPath = p, ""
+			}
+			return next(c)
+		}
+	}
+}
+
+func rewriteRefPath(repos *model.RepoRegistry, p string) string {
+	for _, prefix := range repoPrefixes {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(p, prefix)
+		i := strings.Index(rest, "/")
+		if i < 0 {
+			return p
+		}
+
+		// RepoNamespaces has already escaped the name into one segment
+		name, err := url.PathUnescape(rest[:i])
+		if err != nil {
+			return p
+		}
+		repo := repos.Get(strings.TrimSuffix(name, ".git"))
+		if repo == nil {
+			return p
+		}
+		base, rest := prefix+rest[:i+1], rest[i+1:]
+
+		// single segment refs already work
+		for _, ref := range repo.RefNames() {
+			if !strings.Contains(ref, "/") {
+				continue
+			}
+			for _, pos := range refPositions {
+				if strings.HasPrefix(rest, pos.before+ref) && pos.after(rest[len(pos.before+ref):]) {
+					return base + pos.before + url.PathEscape(ref) + rest[len(pos.before+ref):]
+				}
+			}
+		}
+		return p
+	}
+	return p
+}
@@ -3,6 +3,7 @@ package helper
 // parses repos, refs, commits, blobs, etc. out of request param
 
 import (
+	"github.com/charles-l/gitamite"
 	"github.com/charles-l/gitamite/server/context"
 	"github.com/charles-l/gitamite/server/model"
 
@@ -10,7 +11,13 @@ import (
 	"github.com/libgit2/git2go"
 
 	"fmt"
+	"log"
+	"net/http"
+	"net/url"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func defaultCommit(r *model.Repo, ref *model.Ref) (*model.Commit, error) {
@@ -27,31 +34,115 @@ func defaultCommit(r *model.Repo, ref *model.Ref) (*model.Commit, error) {
 	return model.MakeCommit(gcommit), nil
 }
 
-func RepoParam(c echo.Context) (*model.Repo, error) {
-	repo := c.(*context.Context).Repos[c.Param("repo")]
+// LookupRepo gets the repo without checking if the user can see it, for
+// handlers that do their own access checks
+func LookupRepo(c echo.Context) (*model.Repo, error) {
+	// namespaced names come escaped into one segment (see RepoNamespaces)
+	name, err := url.PathUnescape(c.Param("repo"))
+	if err != nil {
+		return nil, fmt.Errorf("no such repo")
+	}
+	// git clients use NAME.git
+	repo := c.(*context.Context).Repos.Get(strings.TrimSuffix(name, ".git"))
 	if repo == nil {
 		return nil, fmt.Errorf("no such repo")
 	}
 	return repo, nil
 }
 
+func RepoParam(c echo.Context) (*model.Repo, error) {
+	repo, err := LookupRepo(c)
+	if err != nil {
+		return nil, err
+	}
+	if !repo.ACL().CanRead(RequestUser(c, "browse", nil)) {
+		return nil, Unauthorized(c, "this repo is private")
+	}
+	return repo, nil
+}
+
+// RequestUser returns whoever signed the AuthRequest token passed as the
+// basic auth password (see `gitamite token`), or nil for anonymous
+// requests. The token has to have been made for method
+func RequestUser(c echo.Context, method string, nonces gitamite.NonceStore) *model.User {
+	_, token, ok := c.Request().BasicAuth()
+	if !ok {
+		return nil
+	}
+
+	a, err := gitamite.ParseAuthToken(token)
+	if err != nil {
+		return nil
+	}
+
+	e, err := a.VerifyRequest(c.Request().Host, method, nonces)
+	if err != nil {
+		log.Printf("bad auth token from %s: %s", c.RealIP(), err)
+		return nil
+	}
+	return model.UserFromEntity(e)
+}
+
+// Unauthorized asks the client for a token
+func Unauthorized(c echo.Context, msg string) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Basic realm=\"gitamite\"")
+	return echo.NewHTTPError(http.StatusUnauthorized, msg)
+}
+
+// UnescapedParam is a param that might still be escaped: echo routes on the
+// raw path when there is one, so params come out of it as they were sent
+func UnescapedParam(c echo.Context, name string) string {
+	p := c.Param(name)
+	if c.Request().URL.RawPath == "" {
+		return p
+	}
+	if s, err := url.PathUnescape(p); err == nil {
+		return s
+	}
+	return p
+}
+
+// revParam is a :ref or :commit param. Refs with slashes come escaped into
+// one segment (see RefNames)
+func revParam(c echo.Context, name string) string {
+	if s, err := url.PathUnescape(c.Param(name)); err == nil {
+		return s
+	}
+	return c.Param(name)
+}
+
 func RefParam(c echo.Context, allowNil bool) (*model.Ref, error) {
 	repo, _ := RepoParam(c)
-	refstr := c.Param("ref")
+	refstr := revParam(c, "ref")
 	if refstr == "" {
 		if allowNil {
 			return nil, nil
 		} else {
-			refstr = "master"
+			refstr = repo.DefaultBranch()
 		}
 	}
 	ref, err := repo.LookupRef(refstr)
 	return &ref, err
 }
 
+// TagParam is the tag named in the url. Tags with slashes in them come
+// escaped into one segment
+func TagParam(c echo.Context) (*model.Tag, error) {
+	repo, _ := RepoParam(c)
+	name, err := url.PathUnescape(c.Param("tag"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "no such tag")
+	}
+	t, err := repo.LookupTag(name)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return t, nil
+}
+
 // sanatizes the path: don't every call Param("*") directly
 func PathParam(c echo.Context) string {
-	p := path.Clean(c.Param("*"))
+	p := path.Clean(UnescapedParam(c, "*"))
 	if p == "" || p == "." {
 		p = "/"
 	}
@@ -61,19 +152,168 @@ func PathParam(c echo.Context) string {
 func CommitParam(c echo.Context) (*model.Commit, error) {
 	repo, _ := RepoParam(c)
 	var commit *model.Commit
-	commitstr := c.Param("commit")
+	commitstr := revParam(c, "commit")
 	if commitstr == "" {
 		ref, err := RefParam(c, false)
 		if err != nil {
 			return nil, err
 		}
-		commit, _ = defaultCommit(repo, ref)
+		// a tag can point at a tree or blob
+		if commit, err = defaultCommit(repo, ref); err != nil {
+			return nil, fmt.Errorf("%s isn't a commit", ref.NiceName())
+		}
 	} else {
 		var err error
-		commit, err = repo.LookupCommit(commitstr)
+		commit, err = repo.ResolveCommit(commitstr)
 		if err != nil {
 			return nil, err
 		}
 	}
 	return commit, nil
 }
+
+// LinkCommit is commit if it was given in the url, or nil if the page is on
+// the default branch, for links that should stay where the page is
+func LinkCommit(c echo.Context, commit *model.Commit) *model.Commit {
+	if c.Param("commit") == "" {
+		return nil
+	}
+	return commit
+}
+
+// RevParam is the revision a page is at, as it was given in the url: a
+// commit, a ref, or HEAD for the default branch
+func RevParam(c echo.Context) string {
+	if commit := revParam(c, "commit"); commit != "" {
+		return commit
+	}
+	if ref := revParam(c, "ref"); ref != "" {
+		return ref
+	}
+	return "HEAD"
+}
+
+var archiveFormats = []string{"tar.gz", "zip"}
+
+// ArchiveParam parses REV.FORMAT or REV/DIR.FORMAT out of an archive url
+// into the commit, the rev as given, the directory (or "") and the format
+func ArchiveParam(c echo.Context) (*model.Commit, string, string, string, error) {
+	repo, _ := RepoParam(c)
+
+	rev, dir := revParam(c, "commit"), ""
+	if c.Param("*") != "" {
+		dir = PathParam(c)
+	}
+
+	last := &rev
+	if dir != "" {
+		last = &dir
+	}
+	format := ""
+	for _, f := range archiveFormats {
+		if strings.HasSuffix(*last, "."+f) {
+			format = f
+			*last = strings.TrimSuffix(*last, "."+f)
+		}
+	}
+	if format == "" {
+		return nil, "", "", "", fmt.Errorf("archives can be .%s", strings.Join(archiveFormats, " or ."))
+	}
+
+	commit, err := repo.ResolveCommit(rev)
+	if err != nil {
+		return nil, "", "", "", err
+	}
+	return commit, rev, strings.TrimPrefix(dir, "/"), format, nil
+}
+
+// SearchQueryParam reads a code search from the query string: q, regex
+// (any value), path and lang
+func SearchQueryParam(c echo.Context) model.SearchQuery {
+	return model.SearchQuery{
+		Text:  c.QueryParam("q"),
+		Regex: c.QueryParam("regex") != "",
+		Path:  c.QueryParam("path"),
+		Lang:  c.QueryParam("lang"),
+	}
+}
+
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// LogOptionsParam reads the log's page and filters from the query string:
+// after, limit, author, committer, path, since, until (YYYY-MM-DD or
+// RFC 3339), grep and merges (only/no)
+func LogOptionsParam(c echo.Context) (model.LogOptions, error) {
+	o := model.LogOptions{
+		After:     c.QueryParam("after"),
+		Author:    c.QueryParam("author"),
+		Committer: c.QueryParam("committer"),
+		Path:      strings.Trim(c.QueryParam("path"), "/"),
+		Grep:      c.QueryParam("grep"),
+		Merges:    c.QueryParam("merges"),
+	}
+
+	if l := c.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil {
+			return o, fmt.Errorf("bad limit %s", l)
+		}
+		o.Limit = n
+	}
+
+	var err error
+	if s := c.QueryParam("since"); s != "" {
+		if o.Since, err = parseDate(s); err != nil {
+			return o, fmt.Errorf("bad since date %s", s)
+		}
+	}
+	if s := c.QueryParam("until"); s != "" {
+		if o.Until, err = parseDate(s); err != nil {
+			return o, fmt.Errorf("bad until date %s", s)
+		}
+		if len(s) == len("2006-01-02") {
+			// the whole day
+			o.Until = o.Until.Add(24*time.Hour - time.Second)
+		}
+	}
+
+	if o.Merges != "" && o.Merges != "only" && o.Merges != "no" {
+		return o, fmt.Errorf("merges can be only or no")
+	}
+	return o, nil
+}
+
+// DiffOptionsParam reads the diff settings from the query string: context
+// (lines) and w (all, change or eol) for ignoring whitespace
+func DiffOptionsParam(c echo.Context) (model.DiffOptions, error) {
+	o := model.DiffOptions{Context: model.DefaultDiffContext}
+	if s := c.QueryParam("context"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return o, fmt.Errorf("bad context %s", s)
+		}
+		o.Context = n
+	}
+
+	switch w := c.QueryParam("w"); w {
+	case "", "all", "change", "eol":
+		o.IgnoreWhitespace = w
+	default:
+		return o, fmt.Errorf("w can be all, change or eol")
+	}
+	return o, nil
+}
+
+// DiffModeParam is how to show diffs: "unified" (the default) or "split"
+// for side by side
+func DiffModeParam(c echo.Context) string {
+	if c.QueryParam("mode") == "split" {
+		return "split"
+	}
+	return "unified"
+}
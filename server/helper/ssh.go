@@ -0,0 +1,46 @@
+package helper
+
+import (
+	"github.com/charles-l/gitamite"
+
+	"github.com/labstack/echo"
+
+	"net"
+	"strings"
+)
+
+// SSHAddr is where the ssh server listens. ok is false if it isn't
+// running (there's no host key)
+func SSHAddr() (string, bool) {
+	if _, err := gitamite.GetConfigValue("ssh_host_key_path"); err != nil {
+		return "", false
+	}
+	addr, err := gitamite.GetConfigValue("ssh_addr")
+	if err != nil {
+		addr = ":2222"
+	}
+	return addr, true
+}
+
+// SSHCloneURL is the ssh url for the repo name, on the host the request
+// came in on (unless the ssh server only listens on one address), or "" if
+// there's no ssh server
+func SSHCloneURL(c echo.Context, name string) string {
+	addr, ok := SSHAddr()
+	if !ok {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = c.Request().Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+	}
+	return "ssh://git@" + net.JoinHostPort(host, port) + "/repos/" + name
+}
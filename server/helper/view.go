@@ -3,75 +3,54 @@ package helper
 import (
 	"github.com/charles-l/gitamite/server/model"
 	"github.com/gosvg/gosvg"
-	"github.com/libgit2/git2go"
 
 	"bytes"
 )
 
-func RenderLogTree(repo *model.Repo) []byte {
-	type commitNode struct {
-		x, y   float64
-		commit *model.Commit
-		branch string
-	}
+const (
+	graphLaneWidth = 14
+	// the same as .commit-log rows
+	graphRowHeight = 24
+)
 
-	nodes := make(map[string]*commitNode)
+var graphColors = []string{
+	"#0366d6", "#28a745", "#d73a49", "#6f42c1", "#e36209", "#17a2b8", "#b08800", "#e83e8c",
+}
 
-	for i, c := range repo.CommitLog(nil) {
-		y := float64(10 + i*32)
-		x := float64(10)
-		branchName := ""
+func graphX(lane int) float64 {
+	return float64(lane*graphLaneWidth + graphLaneWidth/2)
+}
 
-		nodes[c.Hash()] = &commitNode{
-			x, y, c, branchName,
-		}
-	}
+// RenderGraphRow draws a row of the log graph (see model.LogGraph), to go
+// next to the commit in the log table. Each lane keeps its color
+func RenderGraphRow(row model.GraphRow) []byte {
+	s := gosvg.NewSVG(float64(row.Width*graphLaneWidth), graphRowHeight)
+	mid := float64(graphRowHeight / 2)
 
-	var tweakNodes func(phash, hash string)
-	tweakNodes = func(chash, hash string) {
-		n := nodes[hash]
-		if chash != "" {
-			if n.branch != "" {
-				n.x = nodes[chash].x
-			}
-			n.branch = nodes[chash].branch
+	for _, l := range row.Lines {
+		x1, y1 := graphX(l.From), 0.0
+		if l.FromCommit {
+			x1, y1 = graphX(row.Lane), mid
 		}
-		for i := uint(0); i < n.commit.ParentCount(); i++ {
-			tweakNodes(n.commit.Id().String(), n.commit.Parent(i).Id().String())
+		x2, y2 := graphX(l.To), float64(graphRowHeight)
+		if l.ToCommit {
+			x2, y2 = graphX(row.Lane), mid
 		}
-	}
 
-	refs := repo.Refs()
-	for i, r := range refs {
-		o, err := r.Branch().Peel(git.ObjectCommit)
-		if err != nil {
-			continue
-		}
-		c, _ := o.AsCommit()
-		n, _ := r.Branch().Name()
-
-		p := nodes[c.Id().String()]
-		p.branch = n
-		p.x = float64(10 + i*32)
-
-		tweakNodes("", p.commit.Id().String())
+		line := s.Line(x1, y1, x2, y2)
+		line.Style.Set("stroke-width", "2")
+		line.Style.Set("stroke", graphColors[l.Lane%len(graphColors)])
 	}
 
-	s := gosvg.NewSVG(float64((len(refs)-1)*32+20), float64((len(nodes)-1)*32+20))
-	s.Class = "id=\"commit-graph\""
-
-	for _, n := range nodes {
-		s.Circle(n.x, n.y, 4)
-		for i := uint(0); i < n.commit.ParentCount(); i++ {
-			p := nodes[n.commit.Parent(i).Id().String()]
-			l := s.Line(n.x, n.y, p.x, p.y)
-			l.Style.Set("stroke-width", "2")
-			l.Style.Set("stroke", "black")
-		}
+	c := s.Circle(graphX(row.Lane), mid, 4)
+	c.Style.Set("fill", graphColors[row.Lane%len(graphColors)])
+	if len(row.Refs) > 0 {
+		// tips get a ring, so they stand out
+		c.Style.Set("stroke", "black")
+		c.Style.Set("stroke-width", "1.5")
 	}
 
 	var b bytes.Buffer
 	s.Render(&b)
-
 	return b.Bytes()
 }
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+)
+
+// ACL is who can do what to a repo. Users are identified by email, as in
+// the keyring
+type ACL struct {
+	Owner         string
+	Collaborators []string
+	Private       bool
+}
+
+// ACL returns the repo's access list. Repos without one (i.e. created
+// before there were ACLs) are public and any key in the keyring can push
+// to them, but they have no owner until one is set in the database
+func (r *Repo) ACL() ACL {
+	var a ACL
+	db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("acls")).Get([]byte(r.Name)); v != nil {
+			return json.Unmarshal(v, &a)
+		}
+		return nil
+	})
+	return a
+}
+
+func (r *Repo) SetACL(a ACL) error {
+	blob, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("acls")).Put([]byte(r.Name), blob)
+	})
+}
+
+func DeleteACL(name string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("acls")).Delete([]byte(name))
+	})
+}
+
+// IsOwner is true for the owner only. Repos from before ACLs don't have
+// one, so nobody can do owner-only things to them. u can be nil for
+// anonymous requests
+func (a ACL) IsOwner(u *User) bool {
+	return u != nil && a.Owner != "" && a.Owner == u.Email
+}
+
+func (a ACL) CanWrite(u *User) bool {
+	if u == nil {
+		return false
+	}
+	if a.Owner == "" || a.IsOwner(u) {
+		return true
+	}
+	for _, c := range a.Collaborators {
+		if c == u.Email {
+			return true
+		}
+	}
+	return false
+}
+
+func (a ACL) CanRead(u *User) bool {
+	return !a.Private || a.CanWrite(u)
+}
@@ -0,0 +1,191 @@
+package model
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/libgit2/git2go"
+)
+
+// every file in an archive gets the same timestamp so the archive only
+// depends on the tree (and the prefix)
+var archiveTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	// bigger archives are streamed without being cached
+	archiveMaxCacheSize = 16 << 20
+	// the oldest archives are dropped from the cache past this much
+	archiveCacheMaxTotal = 256 << 20
+)
+
+type archiveWriter interface {
+	add(name string, mode git.Filemode, data []byte) error
+	Close() error
+}
+
+type tarGzWriter struct {
+	gz *gzip.Writer
+	*tar.Writer
+}
+
+func (w *tarGzWriter) add(name string, mode git.Filemode, data []byte) error {
+	h := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: archiveTime,
+		Format:  tar.FormatPAX,
+	}
+	switch mode {
+	case git.FilemodeBlobExecutable:
+		h.Mode = 0755
+	case git.FilemodeLink:
+		h.Typeflag, h.Linkname, h.Mode, h.Size = tar.TypeSymlink, string(data), 0777, 0
+		data = nil
+	case git.FilemodeTree:
+		h.Typeflag, h.Mode = tar.TypeDir, 0755
+	}
+	if err := w.WriteHeader(h); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func (w *tarGzWriter) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
+
+type zipWriter struct {
+	*zip.Writer
+}
+
+func (w *zipWriter) add(name string, mode git.Filemode, data []byte) error {
+	h := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: archiveTime}
+	h.SetMode(0644)
+	switch mode {
+	case git.FilemodeBlobExecutable:
+		h.SetMode(0755)
+	case git.FilemodeLink:
+		h.SetMode(os.ModeSymlink | 0777)
+	case git.FilemodeTree:
+		h.SetMode(os.ModeDir | 0755)
+		h.Method = zip.Store
+	}
+	f, err := w.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func newArchiveWriter(w io.Writer, format string) (archiveWriter, error) {
+	switch format {
+	case "tar.gz":
+		gz := gzip.NewWriter(w)
+		return &tarGzWriter{gz, tar.NewWriter(gz)}, nil
+	case "zip":
+		return &zipWriter{zip.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown archive format %s", format)
+}
+
+func (repo *Repo) writeArchive(w io.Writer, tree *git.Tree, prefix, format string) error {
+	a, err := newArchiveWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	if err := a.add(prefix+"/", git.FilemodeTree, nil); err != nil {
+		return err
+	}
+
+	var werr error
+	err = tree.Walk(func(dir string, e *git.TreeEntry) int {
+		name := path.Join(prefix, dir, e.Name)
+		switch e.Filemode {
+		case git.FilemodeCommit:
+			// submodules aren't part of this repo
+			return 0
+		case git.FilemodeTree:
+			werr = a.add(name+"/", e.Filemode, nil)
+		default:
+			var b *git.Blob
+			if b, werr = repo.LookupBlob(e.Id); werr == nil {
+				werr = a.add(name, e.Filemode, b.Contents())
+			}
+		}
+		if werr != nil {
+			return -1
+		}
+		return 0
+	})
+	if werr != nil {
+		return werr
+	}
+	if err != nil {
+		return err
+	}
+	return a.Close()
+}
+
+// cappedBuffer keeps what's written to it until there's more than max,
+// then drops it and sets full. Writes never fail
+type cappedBuffer struct {
+	bytes.Buffer
+	max  int
+	full bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.full {
+		return len(p), nil
+	}
+	if b.Len()+len(p) > b.max {
+		b.full = true
+		b.Reset()
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// Archive writes tree (with everything under prefix/) as a tar.gz or zip.
+// Archives are cached by tree so the same tree always gives the same bytes,
+// whichever commit it was asked for through. Big ones are only ever
+// streamed
+func (repo *Repo) Archive(w io.Writer, tree *git.Tree, prefix, format string) error {
+	k := []byte("archive:" + tree.Id().String() + ":" + prefix + "." + format)
+
+	var cached []byte
+	db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("archiveCache")).Get(k); v != nil {
+			cached = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if cached != nil {
+		_, err := w.Write(cached)
+		return err
+	}
+
+	buf := &cappedBuffer{max: archiveMaxCacheSize}
+	if err := repo.writeArchive(io.MultiWriter(w, buf), tree, prefix, format); err != nil {
+		return err
+	}
+	if buf.full {
+		return nil
+	}
+	return putCached("archiveCache", k, buf.Bytes(), archiveCacheMaxTotal)
+}
@@ -0,0 +1,430 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/libgit2/git2go"
+)
+
+const (
+	// IgnoreRevsFile is where a repo lists commits (formatting sweeps and
+	// the like) for blame to look past, as with git blame --ignore-revs-file
+	IgnoreRevsFile = ".git-blame-ignore-revs"
+	// the server's own list for a repo, kept in the repo's directory
+	ignoreRevsSetting = "blame-ignore-revs"
+	// a line is passed back past at most this many ignored commits
+	blameMaxSkips = 10
+)
+
+// BlameHunk is a run of lines last changed by the same commit. Start is the
+// first line (from 1), Path and OrigStart are what the file was called and
+// where the lines were in Commit, and Parent is nil if Commit is where the
+// file started. Skipped is the ignored commit the lines were passed back
+// past, if any
+type BlameHunk struct {
+	Commit    *Commit
+	Parent    *Commit
+	Skipped   *Commit
+	Path      string
+	Start     int
+	OrigStart int
+	Lines     int
+	Signature *git.Signature
+	// the keyring entry for Signature, or nil
+	User *User
+}
+
+// Name is who made the hunk's commit, from the keyring if they're in it
+func (h BlameHunk) Name() string {
+	if h.User != nil {
+		return strings.TrimSpace(h.User.Name)
+	}
+	return h.Signature.Name
+}
+
+type Blame struct {
+	Hunks []BlameHunk
+	*Blob
+}
+
+// blameAges is how many shades hunks are colored in by age
+const blameAges = 10
+
+// Age is how old h is compared to the rest of the file, from 0 (the newest
+// hunk) to 9 (the oldest)
+func (b *Blame) Age(h BlameHunk) int {
+	newest, oldest := h.Signature.When, h.Signature.When
+	for _, o := range b.Hunks {
+		if t := o.Signature.When; t.After(newest) {
+			newest = t
+		} else if t.Before(oldest) {
+			oldest = t
+		}
+	}
+	span := newest.Sub(oldest)
+	if span <= 0 {
+		return 0
+	}
+	return int(int64(blameAges-1) * int64(newest.Sub(h.Signature.When)) / int64(span))
+}
+
+// resolveHash is the commit rev names, without looking up its committer
+// like ResolveCommit does
+func (repo *Repo) resolveHash(rev string) (string, error) {
+	o, err := repo.RevparseSingle(rev)
+	if err != nil {
+		return "", err
+	}
+	c, err := o.Peel(git.ObjectCommit)
+	if err != nil {
+		return "", err
+	}
+	return c.Id().String(), nil
+}
+
+// parseIgnoreRevs adds the commits in an ignore-revs file (one per line, #
+// for comments) to ignored. Ones that don't exist are left out, like git
+// does
+func (repo *Repo) parseIgnoreRevs(data []byte, ignored map[string]bool) {
+	for _, l := range strings.Split(string(data), "\n") {
+		if i := strings.Index(l, "#"); i >= 0 {
+			l = l[:i]
+		}
+		if l = strings.TrimSpace(l); l == "" {
+			continue
+		}
+		if hash, err := repo.resolveHash(l); err == nil {
+			ignored[hash] = true
+		}
+	}
+}
+
+// BlameIgnoreRevs is the server's list of commits blame looks past in
+// repo, on top of the ones in IgnoreRevsFile
+func (repo *Repo) BlameIgnoreRevs() []string {
+	data, err := ioutil.ReadFile(path.Join(repo.Filepath, ignoreRevsSetting))
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(data))
+}
+
+// SetBlameIgnoreRevs replaces the server's list of commits blame looks past
+func (repo *Repo) SetBlameIgnoreRevs(revs []string) error {
+	var hashes []string
+	for _, r := range revs {
+		hash, err := repo.resolveHash(r)
+		if err != nil {
+			return fmt.Errorf("no commit %s", r)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	p := path.Join(repo.Filepath, ignoreRevsSetting)
+	if len(hashes) == 0 {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return writeFileAtomic(p, []byte(strings.Join(hashes, "\n")+"\n"))
+}
+
+// ignoredRevs are the commits blame at commit looks past: the server's
+// list, and the ones in commit's IgnoreRevsFile
+func (repo *Repo) ignoredRevs(commit *Commit) map[string]bool {
+	ignored := make(map[string]bool)
+	repo.parseIgnoreRevs([]byte(strings.Join(repo.BlameIgnoreRevs(), "\n")), ignored)
+	if b, err := repo.ReadBlob(commit, IgnoreRevsFile); err == nil {
+		repo.parseIgnoreRevs(b.ByteArray(), ignored)
+	}
+	return ignored
+}
+
+// blameLine is who a line is blamed on: the commit, and where the line was
+// in it
+type blameLine struct {
+	commit   *git.Oid
+	sig      *git.Signature
+	path     string
+	line     int
+	boundary bool
+	// the first ignored commit the line was passed back past
+	skipped *git.Oid
+}
+
+// blamer blames files, and passes lines back past ignored commits. Blames
+// and diffs are kept, since an ignored commit usually has lots of lines
+type blamer struct {
+	repo   *Repo
+	blames map[string]*git.Blame
+	// for each ignored commit and path, the line each line was in the
+	// parent, or 0 if it's new
+	before map[string]map[int]int
+}
+
+func (b *blamer) blame(commit *git.Oid, filepath string) (*git.Blame, error) {
+	k := commit.String() + ":" + filepath
+	if bl, ok := b.blames[k]; ok {
+		return bl, nil
+	}
+	o, _ := git.DefaultBlameOptions()
+	o.NewestCommit = commit
+	bl, err := b.repo.BlameFile(filepath, &o)
+	if err != nil {
+		return nil, err
+	}
+	b.blames[k] = bl
+	return bl, nil
+}
+
+func (b *blamer) free() {
+	for _, bl := range b.blames {
+		bl.Free()
+	}
+}
+
+// linesBefore maps the lines of filepath in c to the lines they were in
+// c's first parent. Changed lines are paired up in order with the lines
+// they replaced, the same as git's (non fuzzy) ignore-revs
+func (b *blamer) linesBefore(c *git.Commit, filepath string) (map[int]int, error) {
+	k := c.Id().String() + ":" + filepath
+	if m, ok := b.before[k]; ok {
+		return m, nil
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	parent, err := c.Parent(0).Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	o, _ := git.DefaultDiffOptions()
+	o.Pathspec = []string{filepath}
+	// the path is a file name, not a pattern (it could have * or [ in it)
+	o.Flags |= git.DiffDisablePathspecMatch
+	// enough context for every unchanged line to be in the diff
+	o.ContextLines = 1 << 24
+	diff, err := b.repo.DiffTreeToTree(parent, tree, &o)
+	if err != nil {
+		return nil, err
+	}
+	defer diff.Free()
+
+	m := make(map[int]int)
+	var deleted, added []int
+	pair := func() {
+		for i, l := range added {
+			if i < len(deleted) {
+				m[l] = deleted[i]
+			}
+		}
+		deleted, added = deleted[:0], added[:0]
+	}
+
+	changed := false
+	err = diff.ForEach(func(file git.DiffDelta, progress float64) (git.DiffForEachHunkCallback, error) {
+		changed = true
+		return func(hunk git.DiffHunk) (git.DiffForEachLineCallback, error) {
+			return func(line git.DiffLine) error {
+				switch line.Origin {
+				case git.DiffLineDeletion:
+					deleted = append(deleted, line.OldLineno)
+				case git.DiffLineAddition:
+					added = append(added, line.NewLineno)
+				case git.DiffLineContext:
+					pair()
+					m[line.NewLineno] = line.OldLineno
+				}
+				return nil
+			}, nil
+		}, nil
+	}, git.DiffDetailLines)
+	if err != nil {
+		return nil, err
+	}
+	pair()
+
+	if !changed {
+		// the file is the same as in the parent
+		m = nil
+	}
+	b.before[k] = m
+	return m, nil
+}
+
+// skip passes l back past its (ignored) commit to whoever wrote the line
+// before. ok is false if the commit added the line, or it can't be told
+// where it came from
+func (b *blamer) skip(l blameLine) (blameLine, bool) {
+	c, err := b.repo.Repository.LookupCommit(l.commit)
+	if err != nil || c.ParentCount() == 0 {
+		return l, false
+	}
+
+	before, err := b.linesBefore(c, l.path)
+	if err != nil {
+		return l, false
+	}
+	line := l.line
+	if before != nil {
+		if line = before[l.line]; line == 0 {
+			return l, false
+		}
+	}
+
+	bl, err := b.blame(c.ParentId(0), l.path)
+	if err != nil {
+		return l, false
+	}
+	hunk, err := bl.HunkByLine(line)
+	if err != nil {
+		return l, false
+	}
+
+	skipped := l.skipped
+	if skipped == nil {
+		skipped = l.commit
+	}
+	p := hunk.OrigPath
+	if p == "" {
+		p = l.path
+	}
+	return blameLine{
+		hunk.FinalCommitId,
+		hunk.FinalSignature,
+		p,
+		int(hunk.OrigStartLineNumber) + line - int(hunk.FinalStartLineNumber),
+		hunk.Boundary,
+		skipped,
+	}, true
+}
+
+// ReadBlobBlame blames filepath at commit, grouping consecutive lines from
+// the same commit into hunks. Lines from commits in the repo's ignore-revs
+// (see ignoredRevs) are passed back to whoever wrote them before
+func (repo *Repo) ReadBlobBlame(commit *Commit, filepath string) (*Blame, error) {
+	blob, err := repo.ReadBlob(commit, filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &blamer{repo, make(map[string]*git.Blame), make(map[string]map[int]int)}
+	defer b.free()
+	blame, err := b.blame(commit.Id(), filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []blameLine
+	for i := 0; i < blame.HunkCount(); i++ {
+		hunk, err := blame.HunkByIndex(i)
+		if err != nil {
+			return nil, err
+		}
+		p := hunk.OrigPath
+		if p == "" {
+			p = filepath
+		}
+		for j := 0; j < int(hunk.LinesInHunk); j++ {
+			lines = append(lines, blameLine{
+				hunk.FinalCommitId,
+				hunk.FinalSignature,
+				p,
+				int(hunk.OrigStartLineNumber) + j,
+				hunk.Boundary,
+				nil,
+			})
+		}
+	}
+
+	if ignored := repo.ignoredRevs(commit); len(ignored) > 0 {
+		for i := range lines {
+			for n := 0; n < blameMaxSkips && ignored[lines[i].commit.String()]; n++ {
+				l, ok := b.skip(lines[i])
+				if !ok {
+					break
+				}
+				lines[i] = l
+			}
+		}
+	}
+
+	// hunks are often from the same few commits
+	commits := make(map[string]*Commit)
+	lookup := func(id *git.Oid) (*Commit, error) {
+		if c, ok := commits[id.String()]; ok {
+			return c, nil
+		}
+		g, err := repo.Repository.LookupCommit(id)
+		if err != nil {
+			return nil, err
+		}
+		c := &Commit{nil, g}
+		commits[id.String()] = c
+		return c, nil
+	}
+	users := make(map[string]*User)
+
+	r := &Blame{nil, blob}
+	for i, l := range lines {
+		if n := len(r.Hunks); n > 0 && lines[i-1].commit.Equal(l.commit) && sameOid(lines[i-1].skipped, l.skipped) {
+			r.Hunks[n-1].Lines++
+			continue
+		}
+
+		c, err := lookup(l.commit)
+		if err != nil {
+			return nil, err
+		}
+		h := BlameHunk{
+			Commit:    c,
+			Path:      l.path,
+			Start:     i + 1,
+			OrigStart: l.line,
+			Lines:     1,
+			Signature: l.sig,
+		}
+		if h.Signature == nil {
+			h.Signature = c.Author()
+		}
+		if c.ParentCount() > 0 && !l.boundary {
+			if h.Parent, err = lookup(c.ParentId(0)); err != nil {
+				return nil, err
+			}
+			// there's nothing to blame in the parent if the file was
+			// added (or renamed) here
+			if entryId(h.Parent.Commit, h.Path) == nil {
+				h.Parent = nil
+			}
+		}
+		if l.skipped != nil {
+			if h.Skipped, err = lookup(l.skipped); err != nil {
+				return nil, err
+			}
+		}
+
+		u, ok := users[h.Signature.Email]
+		if !ok {
+			u = UserFromEmail(h.Signature.Email)
+			users[h.Signature.Email] = u
+		}
+		h.User = u
+		r.Hunks = append(r.Hunks, h)
+	}
+	return r, nil
+}
+
+func sameOid(a, b *git.Oid) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(b)
+}
@@ -5,10 +5,9 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"github.com/boltdb/bolt"
-	"html"
 	"html/template"
-
-	"github.com/charles-l/pygments"
+	"log"
+	"time"
 )
 
 type Blob struct {
@@ -17,20 +16,23 @@ type Blob struct {
 	Data [][]byte
 }
 
-type Blame struct {
-	Users []*User
-	*Blob
-}
-
 func (b *Blob) ByteArray() []byte {
 	return bytes.Join(b.Data, []byte(""))
 }
 
 // TODO: possibly do this for known blobs in a separate thread when staring the server?
 func HighlightedBlobHTML(b *Blob) template.HTML {
+	maxBytes, timeout := highlightLimits()
+	if len(b.ByteArray()) > maxBytes {
+		return template.HTML(plainHTML(b))
+	}
+
+	lexer := GuessLexer(b.Path, b.Data)
+	theme := highlightTheme()
+
 	m := md5.New()
 	m.Write(b.ByteArray())
-	k := []byte("blob:" + hex.EncodeToString(m.Sum(nil)))
+	k := []byte("blob:" + hex.EncodeToString(m.Sum(nil)) + ":" + lexer.Config().Name + ":" + theme.Name)
 
 	var htmlBlob []byte
 
@@ -46,18 +48,35 @@ func HighlightedBlobHTML(b *Blob) template.HTML {
 		return template.HTML(string(htmlBlob))
 	}
 
-	h, err := pygments.Highlight(b.ByteArray(), b.Type, "html", "utf-8")
-	if err != nil {
-		h = "<pre>" + html.EscapeString(string(b.ByteArray())) + "</pre>"
-	}
-	r := template.HTML(h)
+	// some lexers can take forever on pathological input, so give up after
+	// a while and show plain text. the lexer stops at the deadline too,
+	// unless it's stuck on a single token
+	done := make(chan string, 1)
+	go func() {
+		h, err := highlight(b, lexer, theme, time.Now().Add(timeout))
+		if err == errHighlightTimeout {
+			// it might just have been a busy moment, so it's not cached
+			return
+		}
+		if err != nil {
+			log.Printf("failed to highlight %s: %s", b.Path, err)
+			h = plainHTML(b)
+		}
 
-	// theoretically this is safe in a goroutine
-	db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("blobCache"))
-		b.Put(k, []byte(h))
-		return nil
-	})
+		// theoretically this is safe in a goroutine
+		db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("blobCache"))
+			b.Put(k, []byte(h))
+			return nil
+		})
+		done <- h
+	}()
 
-	return r
+	select {
+	case h := <-done:
+		return template.HTML(h)
+	case <-time.After(timeout):
+		log.Printf("highlighting %s timed out", b.Path)
+		return template.HTML(plainHTML(b))
+	}
 }
@@ -21,3 +21,13 @@ func (c Commit) Hash() string {
 func (c Commit) Date() time.Time {
 	return c.Author().When
 }
+
+// Verify checks the commit's gpgsig against the keyring
+func (c Commit) Verify() Verification {
+	sig, signed, err := c.ExtractSignature()
+	if err != nil {
+		// libgit2 errors when there's no signature
+		return Verification{Status: Unsigned}
+	}
+	return verifySignature([]byte(signed), []byte(sig))
+}
@@ -0,0 +1,411 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/libgit2/git2go"
+)
+
+const (
+	MaxCommitSearchResults = 100
+	// commits touching more files than this (big imports, mostly) only
+	// get the first ones indexed
+	commitIndexMaxPaths = 1000
+	// commits are written to bolt this many at a time
+	commitIndexBatch = 1000
+	// longer words (base64, hashes of hashes) aren't indexed, though their
+	// parts still are
+	commitTermMaxLength = 128
+	// trailer keys are indexed under this, so a Path: or Author: trailer
+	// doesn't end up in the path or author fields
+	trailerFieldPrefix = "trailer."
+	// bumped whenever the keys change, so old indexes get rebuilt
+	commitIndexVersion = "2"
+)
+
+var (
+	// things like PROJ-123, #42, log.go or server/model, with their parts
+	// (see terms)
+	termPattern    = regexp.MustCompile(`[\p{L}\p{N}_]+(?:[-./#@+][\p{L}\p{N}_]+)*`)
+	subtermPattern = regexp.MustCompile(`[\p{L}\p{N}_]+`)
+	trailerPattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9-]*):\s*(.+)$`)
+)
+
+// fields an unqualified search term is looked for in. Trailers are in the
+// message too, so they're found either way
+var defaultCommitFields = []string{"message", "author", "path"}
+
+// commitField is the field a FIELD: in a query looks in. Anything that
+// isn't a built in field is a trailer key
+func commitField(f string) string {
+	switch f {
+	case "committer", "email":
+		return "author"
+	case "message", "author", "path":
+		return f
+	}
+	if strings.HasPrefix(f, trailerFieldPrefix) {
+		return f
+	}
+	return trailerFieldPrefix + f
+}
+
+// CommitHit is a commit found by SearchCommits
+type CommitHit struct {
+	Repo    *Repo
+	Hash    string
+	Summary string
+	Author  string
+	Email   string
+	Date    time.Time
+}
+
+// what's kept of each indexed commit, so hits don't need the repo
+type commitDoc struct {
+	Hash    string
+	Summary string
+	Author  string
+	Email   string
+	Date    time.Time
+}
+
+// terms are the lowercased words in s: whole ones like proj-123 or
+// bob@example.com, and the parts of those. Words longer than
+// commitTermMaxLength are left out
+func terms(s string) []string {
+	var ts []string
+	for _, t := range termPattern.FindAllString(strings.ToLower(s), -1) {
+		if len(t) <= commitTermMaxLength {
+			ts = append(ts, t)
+		}
+		if parts := subtermPattern.FindAllString(t, -1); len(parts) > 1 {
+			for _, p := range parts {
+				if len(p) <= commitTermMaxLength {
+					ts = append(ts, p)
+				}
+			}
+		}
+	}
+	return ts
+}
+
+// trailers are the Key: value lines (Signed-off-by, Fixes, etc) in the last
+// paragraph of a message, with the keys lowercased
+func trailers(msg string) [][2]string {
+	paragraphs := strings.Split(strings.TrimSpace(msg), "\n\n")
+	if len(paragraphs) < 2 {
+		return nil
+	}
+
+	var ts [][2]string
+	for _, l := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		m := trailerPattern.FindStringSubmatch(strings.TrimSpace(l))
+		if m == nil {
+			// it's only trailers if it's all trailers
+			return nil
+		}
+		ts = append(ts, [2]string{strings.ToLower(m[1]), m[2]})
+	}
+	return ts
+}
+
+// touchedPaths are the files c changed compared to its first parent
+func (repo *Repo) touchedPaths(c *git.Commit) []string {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil
+	}
+	var parent *git.Tree
+	if c.ParentCount() > 0 {
+		if parent, err = c.Parent(0).Tree(); err != nil {
+			return nil
+		}
+	}
+
+	o, _ := git.DefaultDiffOptions()
+	diff, err := repo.DiffTreeToTree(parent, tree, &o)
+	if err != nil {
+		return nil
+	}
+	defer diff.Free()
+
+	var paths []string
+	n, _ := diff.NumDeltas()
+	for i := 0; i < n && len(paths) < commitIndexMaxPaths; i++ {
+		d, err := diff.GetDelta(i)
+		if err != nil {
+			continue
+		}
+		paths = append(paths, d.NewFile.Path)
+		if d.OldFile.Path != d.NewFile.Path {
+			paths = append(paths, d.OldFile.Path)
+		}
+	}
+	return paths
+}
+
+// commitTerms are the FIELD:TERM keys a commit is found by: message,
+// author (and committer), path, and trailer.KEY for each trailer
+func (repo *Repo) commitTerms(c *git.Commit) map[string]bool {
+	keys := make(map[string]bool)
+	add := func(field string, ts []string) {
+		for _, t := range ts {
+			keys[field+":"+t] = true
+		}
+	}
+
+	add("message", terms(c.Message()))
+	for _, s := range []*git.Signature{c.Author(), c.Committer()} {
+		add("author", terms(s.Name+" "+s.Email))
+	}
+	for _, t := range trailers(c.Message()) {
+		add(trailerFieldPrefix+t[0], terms(t[1]))
+	}
+	for _, p := range repo.touchedPaths(c) {
+		add("path", append(terms(p), strings.ToLower(p)))
+	}
+	return keys
+}
+
+func commitIndexBucket(tx *bolt.Tx, name string) *bolt.Bucket {
+	return tx.Bucket([]byte("commitIndex")).Bucket([]byte(name))
+}
+
+// the commits the last IndexCommits got up to, and whether the index (if
+// there is one) is in the current format
+func (repo *Repo) indexedTips() ([]string, bool) {
+	var tips []string
+	current := true
+	db.View(func(tx *bolt.Tx) error {
+		if b := commitIndexBucket(tx, repo.Name); b != nil {
+			json.Unmarshal(b.Get([]byte("tips")), &tips)
+			current = string(b.Get([]byte("version"))) == commitIndexVersion
+		}
+		return nil
+	})
+	return tips, current
+}
+
+// tips are the commits every branch and tag point to
+func (repo *Repo) tips() []string {
+	var tips []string
+	for _, id := range repo.RefTargets() {
+		oid, err := git.NewOid(id)
+		if err != nil {
+			continue
+		}
+		o, err := repo.Lookup(oid)
+		if err != nil {
+			continue
+		}
+		if c, err := o.Peel(git.ObjectCommit); err == nil {
+			tips = append(tips, c.Id().String())
+		}
+	}
+	return tips
+}
+
+type indexedCommit struct {
+	doc  commitDoc
+	keys map[string]bool
+}
+
+func (repo *Repo) saveCommitIndex(batch []indexedCommit, tips []string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte("commitIndex")).CreateBucketIfNotExists([]byte(repo.Name))
+		if err != nil {
+			return err
+		}
+		commits, err := b.CreateBucketIfNotExists([]byte("commits"))
+		if err != nil {
+			return err
+		}
+		keys, err := b.CreateBucketIfNotExists([]byte("terms"))
+		if err != nil {
+			return err
+		}
+
+		for _, c := range batch {
+			v, _ := json.Marshal(c.doc)
+			if err := commits.Put([]byte(c.doc.Hash), v); err != nil {
+				return err
+			}
+			// FIELD:TERM\0HASH, so a term's commits are a prefix scan
+			for k := range c.keys {
+				if len(k)+1+len(c.doc.Hash) > bolt.MaxKeySize {
+					// a really long path
+					continue
+				}
+				if err := keys.Put([]byte(k+"\x00"+c.doc.Hash), nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		if tips != nil {
+			if err := b.Put([]byte("version"), []byte(commitIndexVersion)); err != nil {
+				return err
+			}
+			v, _ := json.Marshal(tips)
+			return b.Put([]byte("tips"), v)
+		}
+		return nil
+	})
+}
+
+// IndexCommits adds every commit on a branch or tag that isn't in the
+// commit search index yet
+func (repo *Repo) IndexCommits() error {
+	indexed, current := repo.indexedTips()
+	if !current {
+		if err := DeleteCommitIndex(repo.Name); err != nil {
+			return err
+		}
+		indexed = nil
+	}
+
+	w, err := repo.Walk()
+	if err != nil {
+		return err
+	}
+	defer w.Free()
+
+	// walk from the same tips that get saved, so a push in the middle of
+	// this gets picked up next time
+	tips := repo.tips()
+	for _, t := range tips {
+		if id, err := git.NewOid(t); err == nil {
+			w.Push(id)
+		}
+	}
+	for _, t := range indexed {
+		// anything reachable from where we got to last time is done
+		if id, err := git.NewOid(t); err == nil {
+			w.Hide(id)
+		}
+	}
+
+	var batch []indexedCommit
+	id := &git.Oid{}
+	for w.Next(id) == nil {
+		c, err := repo.Repository.LookupCommit(id)
+		if err != nil {
+			return err
+		}
+		a := c.Author()
+		batch = append(batch, indexedCommit{
+			commitDoc{id.String(), c.Summary(), a.Name, a.Email, c.Committer().When},
+			repo.commitTerms(c),
+		})
+
+		if len(batch) == commitIndexBatch {
+			if err := repo.saveCommitIndex(batch, nil); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	return repo.saveCommitIndex(batch, tips)
+}
+
+func DeleteCommitIndex(name string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte("commitIndex")).DeleteBucket([]byte(name))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+type commitClause struct {
+	fields []string
+	term   string
+}
+
+// parseCommitQuery splits a query into words that all have to match.
+// FIELD:WORD only looks in one field: message, author (committer and email
+// are the same thing), path, or a trailer key like fixes or reviewed-by
+func parseCommitQuery(q string) []commitClause {
+	var clauses []commitClause
+	for _, w := range strings.Fields(q) {
+		fields := defaultCommitFields
+		if i := strings.Index(w, ":"); i > 0 && i < len(w)-1 {
+			fields, w = []string{commitField(strings.ToLower(w[:i]))}, w[i+1:]
+		}
+
+		// the whole thing, so proj-123 doesn't match proj-124
+		if ts := terms(w); len(ts) > 0 {
+			clauses = append(clauses, commitClause{fields, ts[0]})
+		}
+	}
+	return clauses
+}
+
+// searchCommitIndex finds repo's commits that match every clause
+func (repo *Repo) searchCommitIndex(clauses []commitClause) []CommitHit {
+	var hits []CommitHit
+	db.View(func(tx *bolt.Tx) error {
+		b := commitIndexBucket(tx, repo.Name)
+		if b == nil || b.Bucket([]byte("terms")) == nil {
+			return nil
+		}
+		keys, commits := b.Bucket([]byte("terms")).Cursor(), b.Bucket([]byte("commits"))
+
+		var found map[string]bool
+		for _, cl := range clauses {
+			matches := make(map[string]bool)
+			for _, f := range cl.fields {
+				prefix := []byte(f + ":" + cl.term + "\x00")
+				for k, _ := keys.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = keys.Next() {
+					hash := string(k[len(prefix):])
+					if found == nil || found[hash] {
+						matches[hash] = true
+					}
+				}
+			}
+			found = matches
+			if len(found) == 0 {
+				return nil
+			}
+		}
+
+		for hash := range found {
+			var d commitDoc
+			if json.Unmarshal(commits.Get([]byte(hash)), &d) == nil {
+				hits = append(hits, CommitHit{repo, d.Hash, d.Summary, d.Author, d.Email, d.Date})
+			}
+		}
+		return nil
+	})
+	return hits
+}
+
+// SearchCommits finds the commits in repos whose message, author, paths or
+// trailers match q (see parseCommitQuery), newest first. more is whether
+// there were more than MaxCommitSearchResults. Repos that haven't been
+// indexed yet have no hits
+func SearchCommits(repos []*Repo, q string) (hits []CommitHit, more bool, err error) {
+	clauses := parseCommitQuery(q)
+	if len(clauses) == 0 {
+		return nil, false, fmt.Errorf("need something to search for")
+	}
+
+	for _, repo := range repos {
+		hits = append(hits, repo.searchCommitIndex(clauses)...)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].Date.After(hits[j].Date)
+	})
+
+	if len(hits) > MaxCommitSearchResults {
+		return hits[:MaxCommitSearchResults], true, nil
+	}
+	return hits, false, nil
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/libgit2/git2go"
+)
+
+// Divergence is how far apart two commits are: the commits one has that
+// the other doesn't (Ahead) and the other way around (Behind)
+type Divergence struct {
+	Ahead  int
+	Behind int
+}
+
+// Diverged counts head's commits that aren't in base and vice versa
+func (repo *Repo) Diverged(head, base *git.Oid) (Divergence, error) {
+	ahead, behind, err := repo.AheadBehind(head, base)
+	if err != nil {
+		return Divergence{}, err
+	}
+	return Divergence{ahead, behind}, nil
+}
+
+// Comparison is what head would bring into base: the commits since their
+// merge base and the diff from the merge base to head
+type Comparison struct {
+	Base      *Commit
+	Head      *Commit
+	MergeBase *Commit
+	Divergence
+	// newest first, at most MaxLogLimit of them
+	Commits []*Commit
+	Diff    Diff
+}
+
+func (repo *Repo) Compare(base, head *Commit, o DiffOptions) (*Comparison, error) {
+	id, err := repo.MergeBase(base.Id(), head.Id())
+	if err != nil {
+		return nil, fmt.Errorf("%s and %s don't have any history in common", base.Hash(), head.Hash())
+	}
+	mergeBase, err := repo.LookupCommit(id.String())
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := repo.Diverged(head.Id(), base.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := repo.Walk()
+	if err != nil {
+		return nil, err
+	}
+	defer w.Free()
+	w.Push(head.Id())
+	w.Hide(base.Id())
+	w.Sorting(git.SortTopological | git.SortTime)
+
+	var commits []*Commit
+	w.Iterate(func(c *git.Commit) bool {
+		commits = append(commits, MakeCommit(c))
+		return len(commits) < MaxLogLimit
+	})
+
+	return &Comparison{
+		base,
+		head,
+		mergeBase,
+		d,
+		commits,
+		GetDiff(repo, head, mergeBase, o),
+	}, nil
+}
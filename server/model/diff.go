@@ -4,20 +4,24 @@ import (
 	"github.com/libgit2/git2go"
 )
 
+// DiffHeader is a hunk's @@ line, and where the hunk's lines start in
+// Lines
+type DiffHeader struct {
+	Header string
+	Start  int
+}
+
+// DiffHunk is the change to one file: every hunk's lines, one after the
+// other, with Headers marking where each hunk starts
 type DiffHunk struct {
 	OldPath string
 	NewPath string
-	Lines   []git.DiffLine
-	*git.DiffHunk
-}
-
-// TODO: make it generate a quick patch that could be curled
-func (h *DiffHunk) AsPatch() *Blob {
-	var data [][]byte
-	for _, l := range h.Lines {
-		data = append(data, []byte(l.Content))
-	}
-	return &Blob{h.NewPath, "", data}
+	// added, deleted, modified, renamed or copied
+	Status string
+	// how alike (0-100) a renamed or copied file is to the original
+	Similarity int
+	Lines      []git.DiffLine
+	Headers    []DiffHeader
 }
 
 type Diff struct {
@@ -28,7 +32,38 @@ type Diff struct {
 	*git.Diff
 }
 
-func GetDiff(repo *Repo, commitA *Commit, commitB *Commit) Diff {
+// DiffOptions are the knobs on the diff page
+type DiffOptions struct {
+	// lines of context around changes
+	Context int
+	// "all", "change" (in amount) or "eol", like git diff -w, -b and
+	// --ignore-space-at-eol
+	IgnoreWhitespace string
+}
+
+const DefaultDiffContext = 3
+
+var whitespaceFlags = map[string]git.DiffOptionsFlag{
+	"all":    git.DiffIgnoreWhitespace,
+	"change": git.DiffIgnoreWhitespaceChange,
+	"eol":    git.DiffIgnoreWhitespaceEOL,
+}
+
+func deltaStatus(d git.Delta) string {
+	switch d {
+	case git.DeltaAdded:
+		return "added"
+	case git.DeltaDeleted:
+		return "deleted"
+	case git.DeltaRenamed:
+		return "renamed"
+	case git.DeltaCopied:
+		return "copied"
+	}
+	return "modified"
+}
+
+func GetDiff(repo *Repo, commitA *Commit, commitB *Commit, opts DiffOptions) Diff {
 	treeA, _ := commitA.Tree()
 	var treeB *git.Tree
 	if commitB == nil {
@@ -37,8 +72,17 @@ func GetDiff(repo *Repo, commitA *Commit, commitB *Commit) Diff {
 		treeB, _ = commitB.Tree()
 	}
 	o, _ := git.DefaultDiffOptions()
+	o.ContextLines = uint32(opts.Context)
+	o.Flags |= whitespaceFlags[opts.IgnoreWhitespace]
 	diff, _ := repo.DiffTreeToTree(treeB, treeA, &o)
 
+	fo, _ := git.DefaultDiffFindOptions()
+	fo.Flags = git.DiffFindRenames | git.DiffFindCopies
+	if opts.IgnoreWhitespace != "" {
+		fo.Flags |= git.DiffFindIgnoreWhitespace
+	}
+	diff.FindSimilar(&fo)
+
 	// TODO: use a struct
 	stats, _ := diff.Stats()
 	statsStr, _ := stats.String(git.DiffStatsFull, 80)
@@ -70,10 +114,14 @@ func GetDiff(repo *Repo, commitA *Commit, commitB *Commit) Diff {
 
 		hunk.OldPath = file.OldFile.Path
 		hunk.NewPath = file.NewFile.Path
+		hunk.Status = deltaStatus(file.Status)
+		if file.Status == git.DeltaRenamed || file.Status == git.DeltaCopied {
+			hunk.Similarity = int(file.Similarity)
+		}
 
 		hunks = append(hunks, &hunk)
 		return func(ghunk git.DiffHunk) (git.DiffForEachLineCallback, error) {
-			hunk.DiffHunk = &ghunk
+			hunk.Headers = append(hunk.Headers, DiffHeader{ghunk.Header, len(hunk.Lines)})
 			return func(line git.DiffLine) error {
 				hunk.Lines = append(hunk.Lines, line)
 				return nil
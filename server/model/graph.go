@@ -0,0 +1,177 @@
+package model
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/libgit2/git2go"
+)
+
+// RefLabel is a branch, tag or remote branch pointing at a commit, for
+// labelling it in the log. Type is "branch", "tag" or "remote"
+type RefLabel struct {
+	Name string
+	Type string
+}
+
+// GraphLine is a line through a row of the log graph, from lane From at
+// the top of the row (or the row's commit, if FromCommit) to lane To at the
+// bottom (or the commit, if ToCommit). Lane is the lane it's colored as
+type GraphLine struct {
+	From       int
+	To         int
+	FromCommit bool
+	ToCommit   bool
+	Lane       int
+}
+
+// GraphRow is a commit's row in the log graph. Lanes are numbered from 0
+// on the left, and Width is how many the row needs
+type GraphRow struct {
+	Commit *Commit
+	Lane   int
+	Width  int
+	Lines  []GraphLine
+	Refs   []RefLabel
+}
+
+// RefLabels are the branches, tags and remote branches pointing at each
+// commit, by hash
+func (repo *Repo) RefLabels() map[string][]RefLabel {
+	labels := make(map[string][]RefLabel)
+	types := []struct{ prefix, kind string }{
+		{"refs/heads/", "branch"},
+		{"refs/tags/", "tag"},
+		{"refs/remotes/", "remote"},
+	}
+
+	for name, id := range repo.RefTargets() {
+		for _, t := range types {
+			if !strings.HasPrefix(name, t.prefix) {
+				continue
+			}
+			oid, err := git.NewOid(id)
+			if err != nil {
+				break
+			}
+			o, err := repo.Lookup(oid)
+			if err != nil {
+				break
+			}
+			// annotated tags point at the tag object
+			c, err := o.Peel(git.ObjectCommit)
+			if err != nil {
+				break
+			}
+			hash := c.Id().String()
+			labels[hash] = append(labels[hash], RefLabel{strings.TrimPrefix(name, t.prefix), t.kind})
+		}
+	}
+
+	for _, l := range labels {
+		sort.Slice(l, func(i, j int) bool {
+			if l[i].Type != l[j].Type {
+				return l[i].Type < l[j].Type
+			}
+			return l[i].Name < l[j].Name
+		})
+	}
+	return labels
+}
+
+func laneOf(lanes []string, hash string) int {
+	for i, l := range lanes {
+		if l == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+// freeLane is the leftmost empty lane, adding one if they're all taken
+func freeLane(lanes *[]string) int {
+	if i := laneOf(*lanes, ""); i >= 0 {
+		return i
+	}
+	*lanes = append(*lanes, "")
+	return len(*lanes) - 1
+}
+
+// LogGraph lays out commits (newest first, as in the log) in lanes, the
+// way git log --graph does: each lane waits for a commit, and a commit
+// takes the lane its first parent then waits in. It only looks at each
+// lane once per row, so it's linear in the number of commits.
+//
+// The log is in time order, so a parent with a skewed clock can come before
+// its child. Lines to parents that have already been passed are left out.
+//
+// complete is whether commits are the whole walk, every parent included.
+// If not (a filtered or first parent log), parents that aren't on the page
+// may never turn up, so lanes are only kept for ones that are
+func LogGraph(commits []*Commit, labels map[string][]RefLabel, complete bool) []GraphRow {
+	onPage := make(map[string]bool, len(commits))
+	for _, c := range commits {
+		onPage[c.Hash()] = true
+	}
+	passed := make(map[string]bool, len(commits))
+
+	// the hash each lane is waiting for, or "" if it's free
+	var lanes []string
+	rows := make([]GraphRow, 0, len(commits))
+	for _, c := range commits {
+		hash := c.Hash()
+		passed[hash] = true
+		row := GraphRow{Commit: c, Refs: labels[hash]}
+		top := append([]string(nil), lanes...)
+
+		row.Lane = laneOf(lanes, hash)
+		if row.Lane < 0 {
+			// a branch tip, or the first commit of a page
+			row.Lane = freeLane(&lanes)
+		}
+
+		for i, l := range top {
+			switch l {
+			case "":
+			case hash:
+				// children in other lanes join up here
+				row.Lines = append(row.Lines, GraphLine{i, row.Lane, false, true, i})
+				lanes[i] = ""
+			default:
+				row.Lines = append(row.Lines, GraphLine{i, i, false, false, i})
+			}
+		}
+		lanes[row.Lane] = ""
+
+		for i := uint(0); i < c.ParentCount(); i++ {
+			parent := c.ParentId(i).String()
+			if passed[parent] || (!onPage[parent] && !complete) {
+				continue
+			}
+
+			to := laneOf(lanes, parent)
+			if to < 0 {
+				if i == 0 {
+					to = row.Lane
+				} else {
+					to = freeLane(&lanes)
+				}
+				lanes[to] = parent
+			}
+			row.Lines = append(row.Lines, GraphLine{row.Lane, to, true, false, to})
+		}
+
+		for len(lanes) > 0 && lanes[len(lanes)-1] == "" {
+			lanes = lanes[:len(lanes)-1]
+		}
+		row.Width = len(top)
+		if len(lanes) > row.Width {
+			row.Width = len(lanes)
+		}
+		if row.Lane >= row.Width {
+			row.Width = row.Lane + 1
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
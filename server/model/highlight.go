@@ -0,0 +1,184 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/chroma"
+	htmlfmt "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/charles-l/gitamite"
+)
+
+const (
+	defaultHighlightTheme    = "github"
+	defaultHighlightMaxBytes = 512 * 1024
+	defaultHighlightTimeout  = 2 * time.Second
+)
+
+var (
+	// vim: set ft=go:  /  vim: filetype=go  /  ex: syntax=go
+	vimModeline = regexp.MustCompile(`(?:vim?|ex):.*?\b(?:ft|filetype|syntax)=([\w+-]+)`)
+	// -*- mode: python -*-  /  -*- python -*-
+	emacsModeline = regexp.MustCompile(`-\*-\s*(?:.*?mode:\s*)?([\w+-]+)\s*(?:;.*?)?-\*-`)
+)
+
+func highlightTheme() *chroma.Style {
+	name, err := gitamite.GetConfigValue("highlight_theme")
+	if err != nil {
+		name = defaultHighlightTheme
+	}
+	return styles.Get(name)
+}
+
+func highlightLimits() (int, time.Duration) {
+	size, timeout := defaultHighlightMaxBytes, defaultHighlightTimeout
+	if v, err := gitamite.GetConfigValue("highlight_max_bytes"); err == nil {
+		if n, err := strconv.Atoi(v); err == nil {
+			size = n
+		}
+	}
+	if v, err := gitamite.GetConfigValue("highlight_timeout"); err == nil {
+		if d, err := time.ParseDuration(v); err == nil {
+			timeout = d
+		}
+	}
+	return size, timeout
+}
+
+// modelines live in the first or last few lines
+func modelineLexer(lines [][]byte) chroma.Lexer {
+	check := lines
+	if len(lines) > 10 {
+		check = append(append([][]byte{}, lines[:5]...), lines[len(lines)-5:]...)
+	}
+	for _, l := range check {
+		for _, re := range []*regexp.Regexp{vimModeline, emacsModeline} {
+			if m := re.FindSubmatch(l); m != nil {
+				if lexer := lexers.Get(string(m[1])); lexer != nil {
+					return lexer
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// #!/usr/bin/python3, #!/usr/bin/env -S node --flag, etc
+func shebangLexer(first []byte) chroma.Lexer {
+	if !bytes.HasPrefix(first, []byte("#!")) {
+		return nil
+	}
+	args := strings.Fields(string(first[2:]))
+	if len(args) == 0 {
+		return nil
+	}
+
+	interp := path.Base(args[0])
+	if interp == "env" {
+		interp = ""
+		for _, a := range args[1:] {
+			if !strings.HasPrefix(a, "-") && !strings.Contains(a, "=") {
+				interp = path.Base(a)
+				break
+			}
+		}
+	}
+
+	// python3.6 -> python3 -> python
+	for interp != "" {
+		if lexer := lexers.Get(interp); lexer != nil {
+			return lexer
+		}
+		trimmed := strings.TrimRight(interp, "0123456789.")
+		if trimmed == interp {
+			break
+		}
+		interp = trimmed
+	}
+	return nil
+}
+
+// GuessLexer works out the language of a file from (in order) its
+// modeline, shebang, filename and contents
+func GuessLexer(filepath string, lines [][]byte) chroma.Lexer {
+	if lexer := modelineLexer(lines); lexer != nil {
+		return lexer
+	}
+	if len(lines) > 0 {
+		if lexer := shebangLexer(lines[0]); lexer != nil {
+			return lexer
+		}
+	}
+	if lexer := lexers.Match(path.Base(filepath)); lexer != nil {
+		return lexer
+	}
+
+	var head []byte
+	for _, l := range lines {
+		if len(head) > 4096 {
+			break
+		}
+		head = append(head, l...)
+	}
+	if lexer := lexers.Analyse(string(head)); lexer != nil {
+		return lexer
+	}
+	return lexers.Fallback
+}
+
+// HighlightStyle is the css for the theme's background and text color,
+// for whatever the highlighted lines get put in
+func HighlightStyle() string {
+	return htmlfmt.StyleEntryToCSS(highlightTheme().Get(chroma.Background))
+}
+
+func plainHTML(b *Blob) string {
+	return html.EscapeString(strings.TrimSuffix(string(b.ByteArray()), "\n"))
+}
+
+// errHighlightTimeout is returned by highlight when it runs out of time
+var errHighlightTimeout = errors.New("highlighting timed out")
+
+// highlight renders one line of html per line of the blob, without any
+// element spanning lines, so the result can be split on \n.
+//
+// It gives up with errHighlightTimeout once deadline passes. Tokens are
+// lexed as they're asked for, so that stops the lexer too
+func highlight(b *Blob, lexer chroma.Lexer, theme *chroma.Style, deadline time.Time) (string, error) {
+	it, err := chroma.Coalesce(lexer).Tokenise(nil, string(b.ByteArray()))
+	if err != nil {
+		return "", err
+	}
+
+	var tokens []chroma.Token
+	for t := it(); t != chroma.EOF; t = it() {
+		if len(tokens)%256 == 0 && time.Now().After(deadline) {
+			return "", errHighlightTimeout
+		}
+		tokens = append(tokens, t)
+	}
+
+	var buf bytes.Buffer
+	for i, line := range chroma.SplitTokensIntoLines(tokens) {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		for _, t := range line {
+			v := html.EscapeString(strings.TrimSuffix(t.Value, "\n"))
+			if css := htmlfmt.StyleEntryToCSS(theme.Get(t.Type)); css != "" {
+				buf.WriteString(`<span style="` + css + `">` + v + `</span>`)
+			} else {
+				buf.WriteString(v)
+			}
+		}
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
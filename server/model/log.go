@@ -0,0 +1,343 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/libgit2/git2go"
+)
+
+const (
+	DefaultLogLimit = 50
+	MaxLogLimit     = 500
+)
+
+// ErrBadCursor is returned for an after cursor that isn't in the log (it's
+// stale, mistyped, or from another branch)
+var ErrBadCursor = errors.New("the commit to page after isn't in this log")
+
+// checkCursor rules out cursors that aren't even commits before walking
+// the whole history looking for them
+func (repo *Repo) checkCursor(after string) error {
+	if after == "" {
+		return nil
+	}
+	oid, err := git.NewOid(after)
+	if err != nil {
+		return ErrBadCursor
+	}
+	if _, err := repo.Repository.LookupCommit(oid); err != nil {
+		return ErrBadCursor
+	}
+	return nil
+}
+
+// LogOptions filters and pages Repo.Log. The zero value is the first page
+// of everything
+type LogOptions struct {
+	// hash of the last commit on the previous page
+	After string
+	Limit int
+
+	// case insensitive substrings of the name or email
+	Author    string
+	Committer string
+	// only commits that changed this file or directory
+	Path string
+	// by commit date, like git log
+	Since time.Time
+	Until time.Time
+	// regexp on the message
+	Grep string
+	// "only" for just merges, "no" for no merges
+	Merges string
+
+	// follow every parent of merges, not just the first, so the graph
+	// shows where branches were merged from
+	AllParents bool
+}
+
+// Filtered is whether o leaves out commits in the middle of the log (so a
+// page's commits might not be next to their parents)
+func (o LogOptions) Filtered() bool {
+	return o.Author != "" || o.Committer != "" || o.Path != "" || o.Grep != "" || o.Merges != "" || !o.Until.IsZero()
+}
+
+func signatureMatches(s *git.Signature, q string) bool {
+	q = strings.ToLower(q)
+	return strings.Contains(strings.ToLower(s.Name), q) || strings.Contains(strings.ToLower(s.Email), q)
+}
+
+func entryId(c *git.Commit, p string) *git.Oid {
+	t, err := c.Tree()
+	if err != nil {
+		return nil
+	}
+	e, err := t.EntryByPath(p)
+	if err != nil || e == nil {
+		return nil
+	}
+	return e.Id
+}
+
+// touches is whether c changed p compared to its first parent
+func touches(c *git.Commit, p string) bool {
+	id := entryId(c, p)
+	if c.ParentCount() == 0 {
+		return id != nil
+	}
+	parent := entryId(c.Parent(0), p)
+	if id == nil || parent == nil {
+		return id != parent
+	}
+	return !id.Equal(parent)
+}
+
+func (o *LogOptions) matches(c *git.Commit, grep *regexp.Regexp) bool {
+	if o.Author != "" && !signatureMatches(c.Author(), o.Author) {
+		return false
+	}
+	if o.Committer != "" && !signatureMatches(c.Committer(), o.Committer) {
+		return false
+	}
+	if !o.Until.IsZero() && c.Committer().When.After(o.Until) {
+		return false
+	}
+	switch o.Merges {
+	case "only":
+		if c.ParentCount() < 2 {
+			return false
+		}
+	case "no":
+		if c.ParentCount() > 1 {
+			return false
+		}
+	}
+	if grep != nil && !grep.MatchString(c.Message()) {
+		return false
+	}
+	if o.Path != "" && !touches(c, o.Path) {
+		return false
+	}
+	return true
+}
+
+// Log is a page of CommitLog (first parent unless o.AllParents, newest
+// first) that matches o. next is the cursor for the page after, or "" if
+// this is the last.
+//
+// Pages are found by walking from the tip again, but only as far as needed,
+// so nothing past the page is ever loaded
+func (repo *Repo) Log(ref *Ref, o LogOptions) ([]*Commit, string, error) {
+	if o.Limit <= 0 {
+		o.Limit = DefaultLogLimit
+	}
+	if o.Limit > MaxLogLimit {
+		o.Limit = MaxLogLimit
+	}
+
+	var grep *regexp.Regexp
+	if o.Grep != "" {
+		var err error
+		if grep, err = regexp.Compile(o.Grep); err != nil {
+			return nil, "", fmt.Errorf("bad grep pattern: %s", err)
+		}
+	}
+
+	if err := repo.checkCursor(o.After); err != nil {
+		return nil, "", err
+	}
+
+	w, err := repo.Walk()
+	if err != nil {
+		return nil, "", err
+	}
+	defer w.Free()
+
+	if ref == nil {
+		w.PushGlob("*")
+	} else {
+		w.Push(ref.Target())
+	}
+	// by time only, since topological order would load the whole history
+	// before the first commit
+	w.Sorting(git.SortTime)
+	if !o.AllParents {
+		w.SimplifyFirstParent()
+	}
+
+	var commits []*Commit
+	more := false
+	skipping := o.After != ""
+	id := &git.Oid{}
+	for w.Next(id) == nil {
+		if skipping {
+			skipping = id.String() != o.After
+			continue
+		}
+
+		c, err := repo.Repository.LookupCommit(id)
+		if err != nil {
+			return nil, "", err
+		}
+		if !o.Since.IsZero() && c.Committer().When.Before(o.Since) {
+			// the walk is by date, so everything after is older too
+			break
+		}
+		if !o.matches(c, grep) {
+			continue
+		}
+
+		if len(commits) == o.Limit {
+			more = true
+			break
+		}
+		commits = append(commits, MakeCommit(c))
+	}
+	if skipping {
+		return nil, "", ErrBadCursor
+	}
+
+	next := ""
+	if more {
+		next = commits[len(commits)-1].Hash()
+	}
+	return commits, next, nil
+}
+
+// HistoryEntry is a commit that changed a path, with what the path was
+// called in that commit
+type HistoryEntry struct {
+	*Commit
+	Path string
+	// set if the file was renamed from OldPath in this commit
+	OldPath string
+}
+
+// renamedFrom finds what p (a file or a directory) was called in c's first
+// parent, if c renamed it. A directory's old name is wherever most of the
+// files in it were moved from
+func (repo *Repo) renamedFrom(c *git.Commit, p string) string {
+	treeA, err := c.Parent(0).Tree()
+	if err != nil {
+		return ""
+	}
+	treeB, err := c.Tree()
+	if err != nil {
+		return ""
+	}
+
+	o, _ := git.DefaultDiffOptions()
+	diff, err := repo.DiffTreeToTree(treeA, treeB, &o)
+	if err != nil {
+		return ""
+	}
+	defer diff.Free()
+
+	fo, _ := git.DefaultDiffFindOptions()
+	fo.Flags = git.DiffFindRenames
+	if diff.FindSimilar(&fo) != nil {
+		return ""
+	}
+
+	dirs := make(map[string]int)
+	best := ""
+	n, _ := diff.NumDeltas()
+	for i := 0; i < n; i++ {
+		d, err := diff.GetDelta(i)
+		if err != nil || d.Status != git.DeltaRenamed {
+			continue
+		}
+		if d.NewFile.Path == p {
+			return d.OldFile.Path
+		}
+
+		// p/a/b.go from old/a/b.go means p was old
+		if !strings.HasPrefix(d.NewFile.Path, p+"/") {
+			continue
+		}
+		rest := strings.TrimPrefix(d.NewFile.Path, p)
+		if !strings.HasSuffix(d.OldFile.Path, rest) {
+			continue
+		}
+		old := strings.TrimSuffix(d.OldFile.Path, rest)
+		if old == "" {
+			continue
+		}
+		if dirs[old]++; dirs[old] > dirs[best] {
+			best = old
+		}
+	}
+	return best
+}
+
+// History is a page of the (first parent) commits from commit back that
+// changed p, following it back through renames. Paging works like Log
+func (repo *Repo) History(commit *Commit, p string, after string, limit int) ([]HistoryEntry, string, error) {
+	if limit <= 0 {
+		limit = DefaultLogLimit
+	}
+	if limit > MaxLogLimit {
+		limit = MaxLogLimit
+	}
+
+	if err := repo.checkCursor(after); err != nil {
+		return nil, "", err
+	}
+
+	w, err := repo.Walk()
+	if err != nil {
+		return nil, "", err
+	}
+	defer w.Free()
+
+	w.Push(commit.Id())
+	w.Sorting(git.SortTime)
+	w.SimplifyFirstParent()
+
+	var entries []HistoryEntry
+	more := false
+	skipping := after != ""
+	id := &git.Oid{}
+	for w.Next(id) == nil {
+		c, err := repo.Repository.LookupCommit(id)
+		if err != nil {
+			return nil, "", err
+		}
+		if p != "" && !touches(c, p) {
+			continue
+		}
+
+		e := HistoryEntry{Path: p}
+		if p != "" && c.ParentCount() > 0 && entryId(c.Parent(0), p) == nil {
+			// it showed up here, so keep going with its old name if
+			// it was renamed
+			if old := repo.renamedFrom(c, p); old != "" {
+				e.OldPath, p = old, old
+			}
+		}
+
+		if skipping {
+			skipping = id.String() != after
+			continue
+		}
+		if len(entries) == limit {
+			more = true
+			break
+		}
+		e.Commit = MakeCommit(c)
+		entries = append(entries, e)
+	}
+	if skipping {
+		return nil, "", ErrBadCursor
+	}
+
+	next := ""
+	if more {
+		next = entries[len(entries)-1].Hash()
+	}
+	return entries, next, nil
+}
@@ -1,8 +1,12 @@
 package model
 
 import (
-	"github.com/boltdb/bolt"
+	"encoding/binary"
 	"log"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
 )
 
 var db *bolt.DB
@@ -15,7 +19,77 @@ func InitDB() *bolt.DB {
 	}
 	db.Update(func(tx *bolt.Tx) error {
 		tx.CreateBucketIfNotExists([]byte("blobCache"))
+		tx.CreateBucketIfNotExists([]byte("nonces"))
+		tx.CreateBucketIfNotExists([]byte("acls"))
+		tx.CreateBucketIfNotExists([]byte("archiveCache"))
+		tx.CreateBucketIfNotExists([]byte("todoCache"))
+		tx.CreateBucketIfNotExists([]byte("pushes"))
+		tx.CreateBucketIfNotExists([]byte("commitIndex"))
 		return nil
 	})
 	return db
 }
+
+// putCached keeps data under k in the cache bucket, then drops the oldest
+// entries until the bucket is back under maxTotal bytes
+func putCached(bucket string, k, data []byte, maxTotal uint64) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		// when each entry was cached and how big it is
+		times, err := b.CreateBucketIfNotExists([]byte("times"))
+		if err != nil {
+			return err
+		}
+
+		if err := b.Put(k, data); err != nil {
+			return err
+		}
+		v := make([]byte, 16)
+		binary.BigEndian.PutUint64(v, uint64(time.Now().UnixNano()))
+		binary.BigEndian.PutUint64(v[8:], uint64(len(data)))
+		if err := times.Put(k, v); err != nil {
+			return err
+		}
+
+		type entry struct {
+			k          []byte
+			when, size uint64
+		}
+		var entries []entry
+		var total uint64
+		times.ForEach(func(k, v []byte) error {
+			e := entry{append([]byte{}, k...), binary.BigEndian.Uint64(v), binary.BigEndian.Uint64(v[8:])}
+			entries = append(entries, e)
+			total += e.size
+			return nil
+		})
+		// entries cached before there were times can't be aged, so they go
+		var untracked [][]byte
+		b.ForEach(func(k, v []byte) error {
+			if v != nil && times.Get(k) == nil {
+				untracked = append(untracked, append([]byte{}, k...))
+			}
+			return nil
+		})
+		for _, k := range untracked {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		sort.Slice(entries, func(i, j int) bool { return entries[i].when < entries[j].when })
+		for _, e := range entries {
+			if total <= maxTotal {
+				break
+			}
+			if err := b.Delete(e.k); err != nil {
+				return err
+			}
+			if err := times.Delete(e.k); err != nil {
+				return err
+			}
+			total -= e.size
+		}
+		return nil
+	})
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// NonceStore keeps the nonces of accepted AuthRequests in the DB, so
+// signed requests can't be replayed
+type NonceStore struct{}
+
+func (NonceStore) UseNonce(nonce string, expires time.Time) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("nonces"))
+
+		// expired nonces can't be replayed anyway (VerifyRequest rejects
+		// the timestamp), so clean them out while we're here
+		var stale [][]byte
+		now := time.Now().Unix()
+		b.ForEach(func(k, v []byte) error {
+			if int64(binary.BigEndian.Uint64(v)) < now {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		for _, k := range stale {
+			b.Delete(k)
+		}
+
+		if b.Get([]byte(nonce)) != nil {
+			return fmt.Errorf("request has already been used")
+		}
+
+		e := make([]byte, 8)
+		binary.BigEndian.PutUint64(e, uint64(expires.Unix()))
+		return b.Put([]byte(nonce), e)
+	})
+}
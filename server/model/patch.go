@@ -0,0 +1,102 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Unified is the diff as git diff prints it, which git apply can apply
+func (d *Diff) Unified() (string, error) {
+	n, err := d.NumDeltas()
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		p, err := d.Patch(i)
+		if err != nil {
+			return "", err
+		}
+		s, err := p.String()
+		p.Free()
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(s)
+	}
+	return buf.String(), nil
+}
+
+// the summary is the first paragraph of the message, and the body is the
+// rest
+func splitMessage(msg string) (string, string) {
+	msg = strings.TrimSpace(msg)
+	parts := strings.SplitN(msg, "\n\n", 2)
+	summary := strings.Join(strings.Fields(parts[0]), " ")
+	if len(parts) == 1 {
+		return summary, ""
+	}
+	return summary, strings.TrimSpace(parts[1])
+}
+
+func (repo *Repo) writePatch(w io.Writer, c *Commit, n, total int) error {
+	var parent *Commit
+	if c.ParentCount() > 0 {
+		parent = MakeCommit(c.Parent(0))
+	}
+	diff := GetDiff(repo, c, parent, DiffOptions{Context: DefaultDiffContext})
+	unified, err := diff.Unified()
+	if err != nil {
+		return err
+	}
+
+	subject := "[PATCH]"
+	if total > 1 {
+		subject = fmt.Sprintf("[PATCH %d/%d]", n, total)
+	}
+	summary, body := splitMessage(c.Message())
+	a := c.Author()
+
+	// the same layout as git format-patch, with its magic date on the
+	// From line
+	fmt.Fprintf(w, "From %s Mon Sep 17 00:00:00 2001\n", c.Hash())
+	fmt.Fprintf(w, "From: %s <%s>\n", a.Name, a.Email)
+	fmt.Fprintf(w, "Date: %s\n", a.When.Format("Mon, 2 Jan 2006 15:04:05 -0700"))
+	fmt.Fprintf(w, "Subject: %s %s\n\n", subject, summary)
+	if body != "" {
+		fmt.Fprintf(w, "%s\n", body)
+	}
+	fmt.Fprintf(w, "---\n%s\n%s-- \ngitamite\n\n", diff.Stats, unified)
+	return nil
+}
+
+// FormatPatch writes commits (oldest first) as an mbox of patches like git
+// format-patch does, so git am can apply them. Merges are skipped, as
+// they are by format-patch
+func (repo *Repo) FormatPatch(w io.Writer, commits []*Commit) error {
+	var series []*Commit
+	for _, c := range commits {
+		if c.ParentCount() < 2 {
+			series = append(series, c)
+		}
+	}
+
+	for i, c := range series {
+		if err := repo.writePatch(w, c, i+1, len(series)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Oldest reverses a newest first list of commits, for FormatPatch
+func Oldest(commits []*Commit) []*Commit {
+	r := make([]*Commit, 0, len(commits))
+	for i := len(commits) - 1; i >= 0; i-- {
+		r = append(r, commits[i])
+	}
+	return r
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/libgit2/git2go"
+)
+
+// Push is one ref updated by a push. Old is empty for a new ref and New is
+// empty for a deleted one
+type Push struct {
+	Repo   string
+	Ref    string
+	Old    string
+	New    string
+	Pusher string
+	Time   time.Time
+}
+
+// RefTargets snapshots where every ref points, so a push can be worked out
+// from the refs before and after it
+func (repo *Repo) RefTargets() map[string]string {
+	targets := make(map[string]string)
+
+	iter, err := repo.NewReferenceIterator()
+	if err != nil {
+		return targets
+	}
+	for {
+		ref, err := iter.Next()
+		if err != nil {
+			break
+		}
+		if ref.Type() == git.ReferenceOid {
+			targets[ref.Name()] = ref.Target().String()
+		}
+	}
+	return targets
+}
+
+// RecordPushes saves a Push for every ref that changed between before and
+// after (see RefTargets)
+func (repo *Repo) RecordPushes(before, after map[string]string, pusher string) {
+	now := time.Now()
+
+	var pushes []Push
+	for name, old := range before {
+		if after[name] != old {
+			pushes = append(pushes, Push{repo.Name, name, old, after[name], pusher, now})
+		}
+	}
+	for name, new := range after {
+		if _, ok := before[name]; !ok {
+			pushes = append(pushes, Push{repo.Name, name, "", new, pusher, now})
+		}
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("pushes"))
+		for _, p := range pushes {
+			// keys sort by time, so the newest pushes are at the end
+			k := make([]byte, 8, 8+len(p.Repo)+len(p.Ref)+1)
+			binary.BigEndian.PutUint64(k, uint64(p.Time.UnixNano()))
+			k = append(append(append(k, p.Repo...), 0), p.Ref...)
+
+			v, _ := json.Marshal(p)
+			if err := b.Put(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to record push to %s: %s", repo.Name, err)
+	}
+}
+
+// RecentPushes returns up to n pushes, newest first, for which keep
+// returns true
+func RecentPushes(n int, keep func(Push) bool) []Push {
+	var pushes []Push
+	db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("pushes")).Cursor()
+		for k, v := c.Last(); k != nil && len(pushes) < n; k, v = c.Prev() {
+			var p Push
+			if json.Unmarshal(v, &p) == nil && keep(p) {
+				pushes = append(pushes, p)
+			}
+		}
+		return nil
+	})
+	return pushes
+}
@@ -2,13 +2,55 @@ package model
 
 import (
 	"github.com/libgit2/git2go"
-	"path/filepath"
+	"sort"
+	"strings"
 )
 
 type Ref struct {
 	*git.Reference
 }
 
+// NiceName is the branch's name without refs/heads/ (which can still have
+// slashes in it, like feature/login)
 func (r Ref) NiceName() string {
-	return filepath.Base(r.Name())
+	return r.Shorthand()
+}
+
+// Verify checks the signature of the commit the ref points to
+func (r Ref) Verify() Verification {
+	c, err := r.Commit()
+	if err != nil {
+		return Verification{Status: Unsigned}
+	}
+	return c.Verify()
+}
+
+// Commit is the commit the ref points to
+func (r Ref) Commit() (*Commit, error) {
+	o, err := r.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+	c, err := o.AsCommit()
+	if err != nil {
+		return nil, err
+	}
+	return MakeCommit(c), nil
+}
+
+// RefNames are the names of the branches and tags (without refs/heads/ or
+// refs/tags/), longest first
+func (repo *Repo) RefNames() []string {
+	var names []string
+	for name := range repo.RefTargets() {
+		for _, prefix := range []string{"refs/heads/", "refs/tags/"} {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, strings.TrimPrefix(name, prefix))
+			}
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return len(names[i]) > len(names[j])
+	})
+	return names
 }
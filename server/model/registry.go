@@ -0,0 +1,267 @@
+package model
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// RepoRegistry is the set of repos being served, which is whatever's in
+// repo_dir. It's safe to use from multiple goroutines, and (once Watch is
+// running) picks up repos that get added, removed or have their description
+// changed on disk.
+//
+// Repos can be nested in namespace directories, so names can have slashes
+// in them (team/project, group/subgroup/project)
+type RepoRegistry struct {
+	dir     string
+	mu      sync.RWMutex
+	repos   map[string]*Repo
+	watcher *fsnotify.Watcher
+}
+
+// NewRepoRegistry loads every repo under dir
+func NewRepoRegistry(dir string) *RepoRegistry {
+	r := &RepoRegistry{dir: dir, repos: make(map[string]*Repo)}
+	r.scan(".")
+	return r
+}
+
+// bare repos have (at least) these, which is also what git checks for
+func isBareRepo(p string) bool {
+	for _, f := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(p, f)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// a repo that git init or clone is still filling in
+func isPartialRepo(p string) bool {
+	for _, f := range []string{"HEAD", "objects", "refs", "config"} {
+		if _, err := os.Stat(filepath.Join(p, f)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RepoRegistry) watch(p string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.watcher != nil {
+		r.watcher.Add(p)
+	}
+}
+
+// scan loads the repo at name (relative to repo_dir), or every repo under
+// it if it's a namespace
+func (r *RepoRegistry) scan(name string) {
+	p := filepath.Join(r.dir, filepath.FromSlash(name))
+	if fi, err := os.Stat(p); err != nil || !fi.IsDir() {
+		return
+	}
+	if r.Get(name) != nil {
+		return
+	}
+
+	if isBareRepo(p) {
+		log.Printf("loading repo from %s\n", p)
+		repo, err := LoadRepository(name, p)
+		if err != nil {
+			log.Printf("failed to load repo %s: %s", p, err)
+			return
+		}
+		r.Add(repo)
+		return
+	}
+
+	// either a namespace or a repo that's still being created, so keep an
+	// eye on it
+	r.watch(p)
+	if isPartialRepo(p) {
+		return
+	}
+
+	entries, _ := ioutil.ReadDir(p)
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			r.scan(path.Join(name, e.Name()))
+		}
+	}
+}
+
+// Get returns nil if there's no such repo
+func (r *RepoRegistry) Get(name string) *Repo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.repos[name]
+}
+
+// All returns every repo, sorted by name
+func (r *RepoRegistry) All() []*Repo {
+	return r.Under("")
+}
+
+// Under returns every repo in namespace ns (however deep), sorted by name
+func (r *RepoRegistry) Under(ns string) []*Repo {
+	prefix := ""
+	if ns != "" {
+		prefix = ns + "/"
+	}
+
+	r.mu.RLock()
+	repos := make([]*Repo, 0, len(r.repos))
+	for name, repo := range r.repos {
+		if strings.HasPrefix(name, prefix) {
+			repos = append(repos, repo)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].Name < repos[j].Name
+	})
+	return repos
+}
+
+// IsNamespace is whether there are any repos in ns
+func (r *RepoRegistry) IsNamespace(ns string) bool {
+	return ns != "" && len(r.Under(ns)) > 0
+}
+
+// Containing returns the repo name is in (or is), if any
+func (r *RepoRegistry) Containing(name string) *Repo {
+	for ; name != "." && name != "/" && name != ""; name = path.Dir(name) {
+		if repo := r.Get(name); repo != nil {
+			return repo
+		}
+	}
+	return nil
+}
+
+func (r *RepoRegistry) Add(repo *Repo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.repos[repo.Name] = repo
+	if r.watcher != nil {
+		// for the description
+		r.watcher.Add(repo.Filepath)
+	}
+}
+
+func (r *RepoRegistry) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if repo := r.repos[name]; repo != nil && r.watcher != nil {
+		r.watcher.Remove(repo.Filepath)
+	}
+	delete(r.repos, name)
+}
+
+// removeUnder unloads the repo name, or everything in it if it was a
+// namespace
+func (r *RepoRegistry) removeUnder(name string) {
+	for _, repo := range r.Under(name) {
+		log.Printf("unloading repo %s", repo.Name)
+		r.Remove(repo.Name)
+	}
+	if r.Get(name) != nil {
+		log.Printf("unloading repo %s", name)
+		r.Remove(name)
+	}
+}
+
+// repos are never changed once they're handed out, so a new description
+// means a new Repo
+func (r *RepoRegistry) reloadDescription(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old := r.repos[name]
+	if old == nil {
+		return
+	}
+	desc, err := ioutil.ReadFile(filepath.Join(old.Filepath, "description"))
+	if err != nil {
+		desc = []byte("")
+	}
+	repo := *old
+	repo.Description = string(desc)
+	r.repos[name] = &repo
+}
+
+// Watch keeps the registry in sync with repo_dir until the watcher fails.
+// Run it in its own goroutine
+func (r *RepoRegistry) Watch() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := w.Add(r.dir); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.watcher = w
+	for _, repo := range r.repos {
+		w.Add(repo.Filepath)
+	}
+	r.mu.Unlock()
+
+	// watch the namespaces, and pick up anything that appeared since
+	r.scan(".")
+
+	for {
+		select {
+		case e, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			r.handleEvent(e)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("watching %s: %s", r.dir, err)
+		}
+	}
+}
+
+func (r *RepoRegistry) handleEvent(e fsnotify.Event) {
+	name, err := filepath.Rel(r.dir, e.Name)
+	if err != nil {
+		return
+	}
+	name = filepath.ToSlash(name)
+	parent := path.Dir(name)
+
+	if repo := r.Containing(parent); repo != nil {
+		// something inside a repo
+		if name == repo.Name+"/description" {
+			r.reloadDescription(repo.Name)
+		}
+		return
+	}
+
+	switch {
+	case e.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		r.removeUnder(name)
+	case parent != "." && isPartialRepo(filepath.Join(r.dir, filepath.FromSlash(parent))):
+		// a repo being filled in
+		r.scan(parent)
+	default:
+		r.scan(name)
+	}
+}
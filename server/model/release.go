@@ -0,0 +1,161 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// release files are sent base64'd in a signed json request, so keep them
+// to something that fits in memory comfortably
+const MaxReleaseFileSize = 64 << 20
+
+// Release is a tag with notes (markdown) and files attached. They're kept
+// under releases/ in the repo's directory, so they go away with the repo
+type Release struct {
+	Tag   *Tag
+	Notes string
+	Files []ReleaseFile
+}
+
+type ReleaseFile struct {
+	Name string
+	Size int64
+	Time time.Time
+}
+
+// LookupTag finds a tag by its short or full name
+func (repo *Repo) LookupTag(name string) (*Tag, error) {
+	if !strings.HasPrefix(name, "refs/tags/") {
+		name = "refs/tags/" + name
+	}
+	for _, t := range repo.Tags() {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("no tag %s", strings.TrimPrefix(name, "refs/tags/"))
+}
+
+func (repo *Repo) releaseDir(t *Tag) string {
+	// tags can have slashes, but each release gets one directory
+	return filepath.Join(repo.Filepath, "releases", url.PathEscape(t.ShortName()))
+}
+
+// validReleaseFile is whether name is safe to use as a file in a release
+func validReleaseFile(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// Release is t's release, or nil if it doesn't have one
+func (repo *Repo) Release(t *Tag) *Release {
+	dir := repo.releaseDir(t)
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+
+	r := &Release{Tag: t}
+	if notes, err := ioutil.ReadFile(filepath.Join(dir, "notes.md")); err == nil {
+		r.Notes = string(notes)
+	}
+
+	infos, _ := ioutil.ReadDir(filepath.Join(dir, "files"))
+	for _, i := range infos {
+		if i.Mode().IsRegular() {
+			r.Files = append(r.Files, ReleaseFile{i.Name(), i.Size(), i.ModTime()})
+		}
+	}
+	return r
+}
+
+// Releases are the tags that have releases, newest first
+func (repo *Repo) Releases() []*Release {
+	var releases []*Release
+	for _, t := range repo.Tags() {
+		if r := repo.Release(t); r != nil {
+			releases = append(releases, r)
+		}
+	}
+	return releases
+}
+
+// writeFileAtomic writes to a temp file first so nobody downloads half of it
+func writeFileAtomic(p string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(p), ".upload")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// SaveRelease makes t a release (if it isn't already) with the given notes
+func (repo *Repo) SaveRelease(t *Tag, notes string) error {
+	dir := repo.releaseDir(t)
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, "notes.md"), []byte(notes))
+}
+
+// AddReleaseFile attaches a file to t's release, making the release if
+// there isn't one yet. A file with the same name is replaced
+func (repo *Repo) AddReleaseFile(t *Tag, name string, data []byte) error {
+	if !validReleaseFile(name) {
+		return fmt.Errorf("bad file name %q", name)
+	}
+	if len(data) > MaxReleaseFileSize {
+		return fmt.Errorf("%s is too big (the limit is %d bytes)", name, MaxReleaseFileSize)
+	}
+
+	dir := filepath.Join(repo.releaseDir(t), "files")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, name), data)
+}
+
+// ReleaseFilePath is where a file attached to t's release is on disk
+func (repo *Repo) ReleaseFilePath(t *Tag, name string) (string, error) {
+	if !validReleaseFile(name) {
+		return "", fmt.Errorf("bad file name %q", name)
+	}
+	p := filepath.Join(repo.releaseDir(t), "files", name)
+	if i, err := os.Stat(p); err != nil || !i.Mode().IsRegular() {
+		return "", fmt.Errorf("%s has no file %s", t.ShortName(), name)
+	}
+	return p, nil
+}
+
+// DeleteReleaseFile removes a file from t's release
+func (repo *Repo) DeleteReleaseFile(t *Tag, name string) error {
+	p, err := repo.ReleaseFilePath(t, name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+// DeleteRelease removes t's release and its files. The tag stays
+func (repo *Repo) DeleteRelease(t *Tag) error {
+	dir := repo.releaseDir(t)
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("%s isn't a release", t.ShortName())
+	}
+	return os.RemoveAll(dir)
+}
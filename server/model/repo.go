@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"log"
 	"path"
+	"strings"
 
 	"github.com/libgit2/git2go"
 )
@@ -17,10 +18,10 @@ type Repo struct {
 	*git.Repository
 }
 
-func LoadRepository(name string, repoPath string) *Repo {
+func LoadRepository(name string, repoPath string) (*Repo, error) {
 	repo, err := git.OpenRepository(repoPath)
 	if err != nil {
-		log.Fatal("failed to open repo ", repoPath, ":", err)
+		return nil, err
 	}
 	desc, err := ioutil.ReadFile(path.Join(repoPath, "description"))
 	if err != nil {
@@ -32,17 +33,71 @@ func LoadRepository(name string, repoPath string) *Repo {
 		repoPath,
 		string(desc),
 		repo,
-	}
+	}, nil
 }
 
+// LookupRef finds a branch, or failing that a tag, by its short name
 func (r *Repo) LookupRef(ref string) (Ref, error) {
 	master, err := r.LookupBranch(ref, git.BranchAll)
 	if err != nil {
+		if tag, terr := r.References.Lookup("refs/tags/" + ref); terr == nil {
+			return Ref{tag}, nil
+		}
 		return Ref{}, fmt.Errorf("failed to fetch ref: " + err.Error())
 	}
 	return Ref{master.Reference}, nil
 }
 
+// DefaultBranch is the branch HEAD points to, whether or not it exists yet
+func (repo *Repo) DefaultBranch() string {
+	head, err := repo.References.Lookup("HEAD")
+	if err != nil || head.Type() != git.ReferenceSymbolic {
+		return "master"
+	}
+	return strings.TrimPrefix(head.SymbolicTarget(), "refs/heads/")
+}
+
+// HeadUnborn is true when HEAD points to a branch that doesn't exist, as in
+// a new repo or one whose first push was to some other branch
+func (repo *Repo) HeadUnborn() bool {
+	unborn, err := repo.IsHeadUnborn()
+	return err != nil || unborn
+}
+
+// SetDefaultBranch points HEAD at a branch
+func (repo *Repo) SetDefaultBranch(name string) error {
+	if _, err := repo.LookupBranch(name, git.BranchLocal); err != nil {
+		return fmt.Errorf("no branch %s", name)
+	}
+	return repo.SetHead("refs/heads/" + name)
+}
+
+// AdoptDefaultBranch points an unborn HEAD at a branch that does exist
+// (main or master if they're there), so the first push to a new repo sets
+// its default branch
+func (repo *Repo) AdoptDefaultBranch() {
+	if !repo.HeadUnborn() {
+		return
+	}
+	refs := repo.Refs()
+	if len(refs) == 0 {
+		return
+	}
+
+	name := refs[0].NiceName()
+	for _, r := range refs {
+		if r.NiceName() == "main" || r.NiceName() == "master" {
+			name = r.NiceName()
+			break
+		}
+	}
+	if err := repo.SetDefaultBranch(name); err != nil {
+		log.Printf("failed to set default branch of %s: %s", repo.Name, err)
+		return
+	}
+	log.Printf("default branch of %s is now %s", repo.Name, name)
+}
+
 func (repo *Repo) Refs() []*Ref {
 	iter, _ := repo.NewBranchIterator(git.BranchLocal)
 
@@ -69,6 +124,26 @@ func (r Repo) LookupCommit(hash string) (*Commit, error) {
 
 }
 
+// ResolveCommit looks up a commit by anything git understands as a
+// revision: a (short) hash, branch, tag, etc
+func (r *Repo) ResolveCommit(rev string) (*Commit, error) {
+	o, err := r.RevparseSingle(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err = o.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := o.AsCommit()
+	if err != nil {
+		return nil, err
+	}
+	return MakeCommit(c), nil
+}
+
 func (repo *Repo) CommitLog(ref *Ref) []*Commit {
 	r, err := repo.Walk()
 	if err != nil {
@@ -114,32 +189,7 @@ func (repo *Repo) ReadBlob(commit *Commit, filepath string) (*Blob, error) {
 		return nil, err
 	}
 
-	ext := path.Ext(filepath)
-	if ext != "" {
-		ext = ext[1:]
-	}
-	return &Blob{filepath, ext, bytes.SplitAfter(b.Contents(), []byte("\n"))}, nil
-}
-
-func (repo *Repo) ReadBlobBlame(commit *Commit, filepath string) (*Blame, error) {
-	o, _ := git.DefaultBlameOptions()
-	o.NewestCommit = commit.Id()
-	blame, err := repo.BlameFile(filepath, &o)
-	if err != nil {
-		return nil, err
-	}
-	blob, err := repo.ReadBlob(commit, filepath)
-	b := Blame{[]*User{}, blob}
-
-	// TODO: handle Windows line endings
-	for i, l := range b.Data {
-		hunk, err := blame.HunkByLine(i)
-		if err != nil {
-			// TODO: FIXME: a quick 'n' dirty hack
-			continue
-		}
-		b.Data = append(b.Data, l)
-		b.Users = append(b.Users, UserFromEmail(hunk.FinalSignature.Email))
-	}
-	return &b, nil
+	data := bytes.SplitAfter(b.Contents(), []byte("\n"))
+	lang := strings.ToLower(GuessLexer(filepath, data).Config().Name)
+	return &Blob{filepath, lang, data}, nil
 }
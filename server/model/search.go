@@ -0,0 +1,380 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/libgit2/git2go"
+)
+
+const (
+	// bigger files aren't searched
+	searchMaxFileSize = 1 << 20
+	// a search stops after this many files, and shows this many lines of
+	// each
+	MaxSearchResults = 100
+	searchMaxLines   = 5
+	// long lines (minified js and the like) are cut down to this much
+	// around the first match
+	searchMaxLineLength = 300
+)
+
+// SearchQuery is what to look for. Text is a case insensitive literal, or a
+// regexp if Regex is set. Path and Lang are case insensitive substrings of
+// the file's path and language. With no Text, files are only matched by
+// Path and Lang
+type SearchQuery struct {
+	Text  string
+	Regex bool
+	Path  string
+	Lang  string
+}
+
+// Empty is whether there's nothing to search for
+func (q SearchQuery) Empty() bool {
+	return q.Text == "" && q.Path == "" && q.Lang == ""
+}
+
+// SearchSpan is part of a line. Match spans are what the query matched
+type SearchSpan struct {
+	Text  string
+	Match bool
+}
+
+type SearchLine struct {
+	Number int
+	Spans  []SearchSpan
+}
+
+// SearchResult is a file on a repo's default branch that matched, with
+// the first few lines that did. More is how many other lines matched
+type SearchResult struct {
+	Repo   *Repo
+	Commit *Commit
+	Path   string
+	Lang   string
+	Lines  []SearchLine
+	More   int
+}
+
+type trigram uint32
+
+func eachTrigram(data []byte, f func(trigram)) {
+	for i := 0; i+3 <= len(data); i++ {
+		f(trigram(data[i])<<16 | trigram(data[i+1])<<8 | trigram(data[i+2]))
+	}
+}
+
+// indexedFile is only where the file is, so the index doesn't keep every
+// file's contents in memory. Files are loaded again to search them
+type indexedFile struct {
+	path string
+	lang string
+	blob *git.Oid
+}
+
+// codeIndex is one repo's default branch. Files are narrowed down by the
+// (lowercased) trigrams in them before actually being searched
+type codeIndex struct {
+	commit *Commit
+	files  []indexedFile
+	// ids of the files each trigram is in, ascending
+	postings map[trigram][]int
+}
+
+func (repo *Repo) buildCodeIndex() (*codeIndex, error) {
+	commit, err := repo.ResolveCommit("HEAD")
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &codeIndex{commit: commit, postings: make(map[trigram][]int)}
+	err = tree.Walk(func(dir string, e *git.TreeEntry) int {
+		if e.Type != git.ObjectBlob {
+			return 0
+		}
+		b, err := repo.LookupBlob(e.Id)
+		if err != nil || b.Size() > searchMaxFileSize {
+			return 0
+		}
+		contents := b.Contents()
+		if bytes.IndexByte(contents, 0) >= 0 {
+			// binary
+			return 0
+		}
+
+		p := path.Join(dir, e.Name)
+		lang := strings.ToLower(GuessLexer(p, bytes.SplitAfter(contents, []byte("\n"))).Config().Name)
+
+		id := len(idx.files)
+		idx.files = append(idx.files, indexedFile{p, lang, e.Id})
+		seen := make(map[trigram]bool)
+		eachTrigram(bytes.ToLower(contents), func(t trigram) {
+			if !seen[t] {
+				seen[t] = true
+				idx.postings[t] = append(idx.postings[t], id)
+			}
+		})
+		return 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+func intersect(a, b []int) []int {
+	var r []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			r = append(r, a[i])
+			i, j = i+1, j+1
+		}
+	}
+	return r
+}
+
+// candidates are the ids of the files that could have lit in them. all is
+// true if lit is too short to narrow anything down
+func (idx *codeIndex) candidates(lit string) (ids []int, all bool) {
+	lower := []byte(strings.ToLower(lit))
+	if len(lower) < 3 {
+		return nil, true
+	}
+
+	first := true
+	eachTrigram(lower, func(t trigram) {
+		if first {
+			ids, first = idx.postings[t], false
+		} else if len(ids) > 0 {
+			ids = intersect(ids, idx.postings[t])
+		}
+	})
+	return ids, false
+}
+
+// matchLine splits a line into matches and the rest, cutting long lines
+// down to the part around the first match
+func matchLine(line string, locs [][]int) []SearchSpan {
+	start, end := 0, len(line)
+	if len(line) > searchMaxLineLength {
+		start = locs[0][0] - searchMaxLineLength/4
+		if start < 0 {
+			start = 0
+		}
+		end = start + searchMaxLineLength
+		if end > len(line) {
+			end = len(line)
+		}
+	}
+
+	var spans []SearchSpan
+	at := start
+	for _, l := range locs {
+		from, to := l[0], l[1]
+		if from < at {
+			from = at
+		}
+		if to > end {
+			to = end
+		}
+		if from >= to {
+			continue
+		}
+		if from > at {
+			spans = append(spans, SearchSpan{line[at:from], false})
+		}
+		spans = append(spans, SearchSpan{line[from:to], true})
+		at = to
+	}
+	if at < end {
+		spans = append(spans, SearchSpan{line[at:end], false})
+	}
+	return spans
+}
+
+// searchFile finds the lines of content that re matches, returning the
+// first few and how many more there were
+func searchFile(content []byte, re *regexp.Regexp) ([]SearchLine, int) {
+	var lines []SearchLine
+	more := 0
+	for i, l := range strings.Split(string(content), "\n") {
+		locs := re.FindAllStringIndex(l, -1)
+		if locs == nil {
+			continue
+		}
+		if len(lines) == searchMaxLines {
+			more++
+			continue
+		}
+		lines = append(lines, SearchLine{i + 1, matchLine(strings.TrimSuffix(l, "\r"), locs)})
+	}
+	return lines, more
+}
+
+// compile works out the regexp to search with, and a literal that matching
+// files have to contain (for the trigrams)
+func (q SearchQuery) compile() (*regexp.Regexp, string, error) {
+	if !q.Regex {
+		return regexp.MustCompile("(?i)" + regexp.QuoteMeta(q.Text)), q.Text, nil
+	}
+	re, err := regexp.Compile(q.Text)
+	if err != nil {
+		return nil, "", fmt.Errorf("bad regexp: %s", err)
+	}
+	lit, _ := re.LiteralPrefix()
+	return re, lit, nil
+}
+
+func (idx *codeIndex) search(repo *Repo, q SearchQuery, re *regexp.Regexp, lit string, limit int) []SearchResult {
+	ids, all := idx.candidates(lit)
+	if all {
+		ids = make([]int, len(idx.files))
+		for i := range ids {
+			ids[i] = i
+		}
+	}
+
+	var results []SearchResult
+	for _, id := range ids {
+		if len(results) == limit {
+			break
+		}
+		f := idx.files[id]
+		if q.Path != "" && !strings.Contains(strings.ToLower(f.path), strings.ToLower(q.Path)) {
+			continue
+		}
+		if q.Lang != "" && !strings.Contains(f.lang, strings.ToLower(q.Lang)) {
+			continue
+		}
+
+		r := SearchResult{repo, idx.commit, f.path, f.lang, nil, 0}
+		if q.Text != "" {
+			b, err := repo.LookupBlob(f.blob)
+			if err != nil {
+				continue
+			}
+			if r.Lines, r.More = searchFile(b.Contents(), re); r.Lines == nil {
+				continue
+			}
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// SearchIndex keeps an index of every repo's default branch for code
+// search, and keeps the commit index (see IndexCommits) up to date. Repos
+// are (re)indexed in the background by Run, after they're queued
+type SearchIndex struct {
+	mu      sync.RWMutex
+	indexes map[string]*codeIndex
+	queued  map[string]bool
+	queue   chan *Repo
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		indexes: make(map[string]*codeIndex),
+		queued:  make(map[string]bool),
+		queue:   make(chan *Repo, 16),
+	}
+}
+
+// Queue has repo indexed again, unless it's already waiting to be
+func (s *SearchIndex) Queue(repo *Repo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queued[repo.Name] {
+		return
+	}
+	s.queued[repo.Name] = true
+	go func() { s.queue <- repo }()
+}
+
+// Remove drops a deleted repo's index
+func (s *SearchIndex) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.indexes, name)
+}
+
+// Run indexes queued repos one at a time. It doesn't return
+func (s *SearchIndex) Run() {
+	for repo := range s.queue {
+		s.mu.Lock()
+		delete(s.queued, repo.Name)
+		old := s.indexes[repo.Name]
+		s.mu.Unlock()
+
+		if _, err := os.Stat(repo.Filepath); os.IsNotExist(err) {
+			// deleted while it was queued
+			continue
+		}
+
+		if err := repo.IndexCommits(); err != nil {
+			log.Printf("failed to index commits of %s: %s", repo.Name, err)
+		}
+
+		if repo.HeadUnborn() {
+			continue
+		}
+		if head, err := repo.ResolveCommit("HEAD"); err == nil && old != nil && head.Hash() == old.commit.Hash() {
+			continue
+		}
+
+		idx, err := repo.buildCodeIndex()
+		if err != nil {
+			log.Printf("failed to index %s for search: %s", repo.Name, err)
+			continue
+		}
+		s.mu.Lock()
+		if _, err := os.Stat(repo.Filepath); err == nil {
+			s.indexes[repo.Name] = idx
+		}
+		s.mu.Unlock()
+		log.Printf("indexed %d files of %s for search", len(idx.files), repo.Name)
+	}
+}
+
+// Search looks through repos' default branches, stopping after
+// MaxSearchResults files. more is whether it stopped early. Repos that
+// haven't been indexed yet are queued and left out
+func (s *SearchIndex) Search(repos []*Repo, q SearchQuery) (results []SearchResult, more bool, err error) {
+	re, lit, err := q.compile()
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, repo := range repos {
+		s.mu.RLock()
+		idx := s.indexes[repo.Name]
+		s.mu.RUnlock()
+		if idx == nil {
+			s.Queue(repo)
+			continue
+		}
+
+		found := idx.search(repo, q, re, lit, MaxSearchResults+1-len(results))
+		results = append(results, found...)
+		if len(results) > MaxSearchResults {
+			return results[:MaxSearchResults], true, nil
+		}
+	}
+	return results, false, nil
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/charles-l/gitamite"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/errors"
+)
+
+type SignatureStatus int
+
+const (
+	Unsigned SignatureStatus = iota
+	Verified
+	UnknownKey
+	BadSignature
+)
+
+func (s SignatureStatus) String() string {
+	switch s {
+	case Verified:
+		return "verified"
+	case UnknownKey:
+		return "unknown key"
+	case BadSignature:
+		return "bad signature"
+	}
+	return "unsigned"
+}
+
+// Slug is the status as a css class
+func (s SignatureStatus) Slug() string {
+	return strings.Replace(s.String(), " ", "-", -1)
+}
+
+// Verification is the result of checking a commit or tag's signature
+// against the keyring. Signer is only set if it's Verified
+type Verification struct {
+	Status SignatureStatus
+	Signer *User
+}
+
+func verifySignature(signed, sig []byte) Verification {
+	if len(sig) == 0 {
+		return Verification{Status: Unsigned}
+	}
+	if !bytes.HasPrefix(sig, []byte("-----BEGIN PGP SIGNATURE-----")) {
+		// x509/ssh signatures, which we can't check
+		return Verification{Status: UnknownKey}
+	}
+
+	p, err := gitamite.GetConfigValue("pubkeyring_path")
+	if err != nil {
+		return Verification{Status: UnknownKey}
+	}
+	keyring, _ := gitamite.ReadKeyringFile(p)
+
+	signer, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(signed), bytes.NewReader(sig))
+	switch {
+	case err == nil:
+		return Verification{Verified, UserFromEntity(signer)}
+	case err == errors.ErrUnknownIssuer:
+		return Verification{Status: UnknownKey}
+	}
+	return Verification{Status: BadSignature}
+}
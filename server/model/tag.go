@@ -0,0 +1,118 @@
+package model
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libgit2/git2go"
+)
+
+var pgpSignatureStart = []byte("-----BEGIN PGP SIGNATURE-----")
+
+type Tag struct {
+	Name   string
+	Commit *Commit
+	// nil for lightweight tags
+	Annotation *git.Tag
+
+	repo *Repo
+}
+
+func (t *Tag) NiceName() string {
+	return t.ShortName()
+}
+
+// ShortName is the tag's name without refs/tags/
+func (t *Tag) ShortName() string {
+	return strings.TrimPrefix(t.Name, "refs/tags/")
+}
+
+// Tagger is who made an annotated tag, or nil for lightweight tags
+func (t *Tag) Tagger() *git.Signature {
+	if t.Annotation == nil {
+		return nil
+	}
+	return t.Annotation.Tagger()
+}
+
+// Date is when an annotated tag was made, or when the commit was for
+// lightweight ones
+func (t *Tag) Date() time.Time {
+	if s := t.Tagger(); s != nil {
+		return s.When
+	}
+	return t.Commit.Date()
+}
+
+// Message is the annotation without the signature, if there is one
+func (t *Tag) Message() string {
+	if t.Annotation == nil {
+		return ""
+	}
+	m := []byte(t.Annotation.Message())
+	if i := bytes.Index(m, pgpSignatureStart); i >= 0 {
+		m = m[:i]
+	}
+	return string(m)
+}
+
+// Verify checks an annotated tag's own signature, or the commit's for a
+// lightweight tag
+func (t *Tag) Verify() Verification {
+	if t.Annotation == nil {
+		return t.Commit.Verify()
+	}
+
+	// signed tags have the signature tacked onto the end of the object
+	odb, err := t.repo.Odb()
+	if err != nil {
+		return Verification{Status: Unsigned}
+	}
+	o, err := odb.Read(t.Annotation.Id())
+	if err != nil {
+		return Verification{Status: Unsigned}
+	}
+	data := o.Data()
+
+	i := bytes.Index(data, pgpSignatureStart)
+	if i < 0 {
+		return Verification{Status: Unsigned}
+	}
+	return verifySignature(data[:i], data[i:])
+}
+
+// Tags returns the tags that point to commits, newest first
+func (repo *Repo) Tags() []*Tag {
+	var tags []*Tag
+	repo.Repository.Tags.Foreach(func(name string, id *git.Oid) error {
+		o, err := repo.Lookup(id)
+		if err != nil {
+			return nil
+		}
+
+		t := &Tag{Name: name, repo: repo}
+		if o.Type() == git.ObjectTag {
+			t.Annotation, _ = o.AsTag()
+		}
+
+		c, err := o.Peel(git.ObjectCommit)
+		if err != nil {
+			return nil
+		}
+		gc, err := c.AsCommit()
+		if err != nil {
+			return nil
+		}
+		t.Commit = MakeCommit(gc)
+
+		tags = append(tags, t)
+		return nil
+	})
+
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Date().After(tags[j].Date())
+	})
+	return tags
+}
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"regexp"
+
+	"github.com/boltdb/bolt"
+	"github.com/libgit2/git2go"
+)
+
+const (
+	// files bigger than this are skipped when looking for todos
+	todoMaxFileSize = 1024 * 1024
+	// at most this many files are blamed for one list, past that todos
+	// don't get an author
+	todoMaxBlames = 100
+	// the oldest lists are dropped from the cache past this much
+	todoCacheMaxTotal = 32 << 20
+)
+
+// a marker right after something that looks like a comment leader
+var todoPattern = regexp.MustCompile(`(?://|#|/\*|\*|--|;|<!--|%)\s*\b(TODO|FIXME|XXX|HACK)\b[:(]?\s*(.*)`)
+
+type Todo struct {
+	Path   string
+	Line   int
+	Kind   string
+	Text   string
+	Author string
+	Email  string
+}
+
+func findTodos(filepath string, contents []byte) []Todo {
+	var todos []Todo
+	for i, l := range bytes.Split(contents, []byte("\n")) {
+		if m := todoPattern.FindSubmatch(l); m != nil {
+			text := bytes.TrimSpace(m[2])
+			text = bytes.TrimSuffix(bytes.TrimSuffix(text, []byte("-->")), []byte("*/"))
+			todos = append(todos, Todo{
+				Path: filepath,
+				Line: i + 1,
+				Kind: string(m[1]),
+				Text: string(bytes.TrimSpace(text)),
+			})
+		}
+	}
+	return todos
+}
+
+func (repo *Repo) blameTodos(commit *Commit, todos []Todo) {
+	o, _ := git.DefaultBlameOptions()
+	o.NewestCommit = commit.Id()
+	blame, err := repo.BlameFile(todos[0].Path, &o)
+	if err != nil {
+		return
+	}
+	defer blame.Free()
+
+	for i := range todos {
+		if hunk, err := blame.HunkByLine(todos[i].Line); err == nil {
+			todos[i].Author = hunk.FinalSignature.Name
+			todos[i].Email = hunk.FinalSignature.Email
+		}
+	}
+}
+
+// Todos collects the TODO/FIXME/XXX/HACK comments in commit's tree, with
+// whoever last touched each line (for the first todoMaxBlames files). They're
+// cached by tree and commit: the todos only depend on the tree, but who
+// wrote them depends on the history up to commit
+func (repo *Repo) Todos(commit *Commit) ([]Todo, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	k := []byte(tree.Id().String() + ":" + commit.Hash())
+
+	var todos []Todo
+	db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("todoCache")).Get(k); v != nil {
+			if json.Unmarshal(v, &todos) != nil {
+				todos = nil
+			}
+		}
+		return nil
+	})
+	if todos != nil {
+		return todos, nil
+	}
+
+	todos = []Todo{}
+	blamed := 0
+	err = tree.Walk(func(dir string, e *git.TreeEntry) int {
+		if e.Type != git.ObjectBlob {
+			return 0
+		}
+		b, err := repo.LookupBlob(e.Id)
+		if err != nil || b.Size() > todoMaxFileSize {
+			return 0
+		}
+		contents := b.Contents()
+		if bytes.IndexByte(contents, 0) >= 0 {
+			// binary
+			return 0
+		}
+
+		if found := findTodos(path.Join(dir, e.Name), contents); len(found) > 0 {
+			if blamed < todoMaxBlames {
+				repo.blameTodos(commit, found)
+				blamed++
+			}
+			todos = append(todos, found...)
+		}
+		return 0
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	blob, _ := json.Marshal(todos)
+	putCached("todoCache", k, blob, todoCacheMaxTotal)
+	return todos, nil
+}
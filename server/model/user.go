@@ -6,6 +6,8 @@ import (
 	"github.com/charles-l/gitamite"
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/ssh"
 	"strings"
 )
 
@@ -56,3 +58,95 @@ func UserFromEmail(email string) *User {
 	}
 	return &u
 }
+
+// openpgp doesn't parse the "authenticate" key flag (RFC 4880 5.2.3.21),
+// so dig it out of the hashed subpackets ourselves
+func canAuthenticate(sig *packet.Signature) bool {
+	if sig == nil || len(sig.HashSuffix) < 6 {
+		return false
+	}
+	size := int(sig.HashSuffix[4])<<8 | int(sig.HashSuffix[5])
+	if 6+size > len(sig.HashSuffix) {
+		return false
+	}
+	sub := sig.HashSuffix[6 : 6+size]
+	for len(sub) > 0 {
+		var l, n int
+		switch {
+		case sub[0] < 192:
+			l, n = int(sub[0]), 1
+		case sub[0] < 255 && len(sub) > 1:
+			l, n = (int(sub[0])-192)<<8+int(sub[1])+192, 2
+		case len(sub) > 4:
+			l, n = int(sub[1])<<24|int(sub[2])<<16|int(sub[3])<<8|int(sub[4]), 5
+		default:
+			return false
+		}
+		if l == 0 || n+l > len(sub) {
+			return false
+		}
+		if sub[n]&0x7f == 27 && l > 1 { // key flags
+			return sub[n+1]&0x20 != 0
+		}
+		sub = sub[n+l:]
+	}
+	return false
+}
+
+// SSHKeys returns the authentication (sub)keys of the user's PGP key as
+// ssh keys
+func (u *User) SSHKeys() []ssh.PublicKey {
+	var keys []ssh.PublicKey
+
+	add := func(pk *packet.PublicKey) {
+		if k, err := ssh.NewPublicKey(pk.PublicKey); err == nil {
+			keys = append(keys, k)
+		}
+	}
+
+	for _, id := range u.Entity.Identities {
+		if canAuthenticate(id.SelfSignature) {
+			add(u.Entity.PrimaryKey)
+			break
+		}
+	}
+	for _, s := range u.Entity.Subkeys {
+		if canAuthenticate(s.Sig) {
+			add(s.PublicKey)
+		}
+	}
+	return keys
+}
+
+func UserFromSSHKey(key ssh.PublicKey) *User {
+	p, err := gitamite.GetConfigValue("pubkeyring_path")
+	if err != nil {
+		return nil
+	}
+	keys, _ := gitamite.ReadKeyringFile(p)
+
+	for _, e := range keys {
+		u := UserFromEntity(e)
+		if u == nil {
+			continue
+		}
+		for _, k := range u.SSHKeys() {
+			if bytes.Equal(k.Marshal(), key.Marshal()) {
+				return u
+			}
+		}
+	}
+	return nil
+}
+
+// UserFromEntity makes a user out of the key's primary identity (or any
+// identity if none is marked primary)
+func UserFromEntity(e *openpgp.Entity) *User {
+	var u *User
+	for _, id := range e.Identities {
+		if u == nil || (id.SelfSignature != nil && id.SelfSignature.IsPrimaryId != nil && *id.SelfSignature.IsPrimaryId) {
+			u = &User{id.UserId.Name, id.UserId.Email, e}
+		}
+	}
+	return u
+}
@@ -0,0 +1,201 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/libgit2/git2go"
+)
+
+// lines with more tokens than this are just shown as changed, since the
+// word diff is quadratic
+const maxWordDiffTokens = 500
+
+var wordPattern = regexp.MustCompile(`\w+|\s+|.`)
+
+// DiffSpan is part of a line. Changed spans are words that differ from the
+// line it's paired with on the other side
+type DiffSpan struct {
+	Text    string
+	Changed bool
+}
+
+// DiffSide is a line on one (or for context, both) sides of a diff. Line
+// numbers are -1 on the side the line isn't on. Rows that start a hunk
+// only have its Header
+type DiffSide struct {
+	OldLineno int
+	NewLineno int
+	Origin    git.DiffLineType
+	Spans     []DiffSpan
+	Header    string
+}
+
+// Lineno is the line number to show in a unified diff
+func (s *DiffSide) Lineno() int {
+	if s.Origin == git.DiffLineDeletion {
+		return s.OldLineno
+	}
+	return s.NewLineno
+}
+
+// DiffRow is a row of a side by side diff. Either side can be nil where the
+// other side has lines that don't pair up with anything. Rows that start a
+// hunk only have its Header
+type DiffRow struct {
+	Old    *DiffSide
+	New    *DiffSide
+	Header string
+}
+
+func plainSide(l git.DiffLine) *DiffSide {
+	return &DiffSide{l.OldLineno, l.NewLineno, l.Origin, []DiffSpan{{strings.TrimSuffix(l.Content, "\n"), false}}, ""}
+}
+
+// wordDiff marks the words that differ between a and b (by longest common
+// subsequence of words)
+func wordDiff(a, b string) ([]DiffSpan, []DiffSpan) {
+	wa, wb := wordPattern.FindAllString(a, -1), wordPattern.FindAllString(b, -1)
+	if len(wa) > maxWordDiffTokens || len(wb) > maxWordDiffTokens {
+		return []DiffSpan{{a, false}}, []DiffSpan{{b, false}}
+	}
+
+	// lcs[i][j] is the lcs of wa[i:] and wb[j:]
+	lcs := make([][]int, len(wa)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(wb)+1)
+	}
+	for i := len(wa) - 1; i >= 0; i-- {
+		for j := len(wb) - 1; j >= 0; j-- {
+			if wa[i] == wb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] > lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var sa, sb []DiffSpan
+	add := func(spans []DiffSpan, text string, changed bool) []DiffSpan {
+		if n := len(spans); n > 0 && spans[n-1].Changed == changed {
+			spans[n-1].Text += text
+			return spans
+		}
+		return append(spans, DiffSpan{text, changed})
+	}
+	i, j := 0, 0
+	for i < len(wa) || j < len(wb) {
+		switch {
+		case i < len(wa) && j < len(wb) && wa[i] == wb[j]:
+			sa, sb = add(sa, wa[i], false), add(sb, wb[j], false)
+			i, j = i+1, j+1
+		case j == len(wb) || (i < len(wa) && lcs[i+1][j] >= lcs[i][j+1]):
+			sa = add(sa, wa[i], true)
+			i++
+		default:
+			sb = add(sb, wb[j], true)
+			j++
+		}
+	}
+
+	// if hardly anything's the same, highlighting words is just noise
+	if len(wa)+len(wb) > 0 && 4*lcs[0][0] < len(wa)+len(wb) {
+		return []DiffSpan{{a, false}}, []DiffSpan{{b, false}}
+	}
+	return sa, sb
+}
+
+// a run of removed lines followed by added ones, a context line, or the
+// start of a hunk
+type diffBlock struct {
+	header  string
+	context *DiffSide
+	dels    []*DiffSide
+	adds    []*DiffSide
+}
+
+// blocks groups the lines into context and changes, with the word diff
+// worked out for removed and added lines that pair up. Changes never pair
+// up across hunks
+func (h *DiffHunk) blocks() []diffBlock {
+	var blocks []diffBlock
+	var cur *diffBlock
+	headers := h.Headers
+	for i, l := range h.Lines {
+		for len(headers) > 0 && headers[0].Start == i {
+			blocks = append(blocks, diffBlock{header: headers[0].Header})
+			headers = headers[1:]
+			cur = nil
+		}
+
+		switch l.Origin {
+		case git.DiffLineDeletion:
+			if cur == nil || len(cur.adds) > 0 {
+				blocks = append(blocks, diffBlock{})
+				cur = &blocks[len(blocks)-1]
+			}
+			cur.dels = append(cur.dels, plainSide(l))
+		case git.DiffLineAddition:
+			if cur == nil {
+				blocks = append(blocks, diffBlock{})
+				cur = &blocks[len(blocks)-1]
+			}
+			cur.adds = append(cur.adds, plainSide(l))
+		default:
+			blocks = append(blocks, diffBlock{context: plainSide(l)})
+			cur = nil
+		}
+	}
+
+	for _, b := range blocks {
+		for i := 0; i < len(b.dels) && i < len(b.adds); i++ {
+			b.dels[i].Spans, b.adds[i].Spans = wordDiff(b.dels[i].Spans[0].Text, b.adds[i].Spans[0].Text)
+		}
+	}
+	return blocks
+}
+
+// UnifiedRows is the lines in order, with changed words marked
+func (h *DiffHunk) UnifiedRows() []*DiffSide {
+	var rows []*DiffSide
+	for _, b := range h.blocks() {
+		if b.header != "" {
+			rows = append(rows, &DiffSide{Header: b.header})
+			continue
+		}
+		if b.context != nil {
+			rows = append(rows, b.context)
+		}
+		rows = append(append(rows, b.dels...), b.adds...)
+	}
+	return rows
+}
+
+// SplitRows pairs removed lines with the added lines that replaced them,
+// for showing side by side
+func (h *DiffHunk) SplitRows() []DiffRow {
+	var rows []DiffRow
+	for _, b := range h.blocks() {
+		if b.header != "" {
+			rows = append(rows, DiffRow{Header: b.header})
+			continue
+		}
+		if b.context != nil {
+			rows = append(rows, DiffRow{b.context, b.context, ""})
+			continue
+		}
+		for i := 0; i < len(b.dels) || i < len(b.adds); i++ {
+			var r DiffRow
+			if i < len(b.dels) {
+				r.Old = b.dels[i]
+			}
+			if i < len(b.adds) {
+				r.New = b.adds[i]
+			}
+			rows = append(rows, r)
+		}
+	}
+	return rows
+}
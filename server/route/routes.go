@@ -5,70 +5,190 @@ import (
 	"github.com/charles-l/gitamite/server/model"
 	"github.com/labstack/echo"
 
+	"net/url"
 	"path"
+	"strconv"
+	"strings"
 )
 
 func Setup(e *echo.Echo) {
 	e.Static("/a", "pub")
 
-	e.GET("/", handler.Repos)
+	// every page is also served as json under /api/v1 (see the api package
+	// for the schemas)
+	setupPages(e, "")
+	setupPages(e, "/api/v1")
 
-	e.GET("/repo/:repo", handler.FileTree)
-	e.GET("/repo/:repo/refs", handler.Refs)
+	feeds := handler.FeedLinks{Repo: RepoPath, Commit: CommitPath}
+	e.GET("/pushes.atom", handler.PushFeed(feeds))
+	e.GET("/repo/:repo/commits.atom", handler.CommitFeed(feeds))
+	e.GET("/repo/:repo/:ref/commits.atom", handler.CommitFeed(feeds))
+	e.GET("/repo/:repo/tags.atom", handler.TagFeed(feeds))
 
-	e.GET("/repo/:repo/commits", handler.FullCommits)
-	e.GET("/repo/:repo/:ref/commits", handler.Commits)
+	e.GET("/repo/:repo/archive/:commit", handler.Archive)
+	e.GET("/repo/:repo/archive/:commit/*", handler.Archive)
 
-	e.GET("/repo/:repo/blob/*", handler.File)
-	e.GET("/repo/:repo/blame/*", handler.Blame)
+	// git smart HTTP, so /repo/NAME.git can be cloned and pushed to
+	e.GET("/repo/:repo/info/refs", handler.GitInfoRefs)
+	e.POST("/repo/:repo/git-upload-pack", handler.GitUploadPack)
+	e.POST("/repo/:repo/git-receive-pack", handler.GitReceivePack)
 
-	//TODO: add blame version of this
-	e.GET("/repo/:repo/commit/:commit/blob/*", handler.File)
+	e.POST("/repo", handler.CreateRepo)
+	e.DELETE("/repo", handler.DeleteRepo)
+	e.PUT("/repo", handler.UpdateRepo)
 
-	e.GET("/repo/:repo/tree/*", handler.FileTree)
-	e.GET("/repo/:repo/commit/:commit/tree/*", handler.FileTree)
+	e.PUT("/release", handler.UpdateRelease)
+	e.DELETE("/release", handler.DeleteRelease)
+	e.POST("/release/files", handler.UploadReleaseFile)
+}
 
-	e.GET("/repo/:repo/commit/:oidA", handler.Diff)
+func setupPages(e *echo.Echo, prefix string) {
+	e.GET(path.Join("/", prefix), handler.Repos)
 
-	e.POST("/repo", handler.CreateRepo)
-	e.DELETE("/repo", handler.DeleteRepo)
+	// namespaces of repos (see helper.RepoNamespaces)
+	e.GET(prefix+"/namespace/:namespace", handler.Namespace)
+
+	e.GET(prefix+"/search", handler.Search)
+	e.GET(prefix+"/repo/:repo/search", handler.RepoSearch)
+	e.GET(prefix+"/search/commits", handler.CommitSearch)
+	e.GET(prefix+"/repo/:repo/search/commits", handler.RepoCommitSearch)
+
+	e.GET(prefix+"/repo/:repo", handler.FileTree)
+	e.GET(prefix+"/repo/:repo/refs", handler.Refs)
+	e.GET(prefix+"/repo/:repo/tags", handler.Tags)
+
+	e.GET(prefix+"/repo/:repo/releases", handler.Releases)
+	e.GET(prefix+"/repo/:repo/releases/:tag", handler.Release)
+	e.GET(prefix+"/repo/:repo/releases/:tag/files/:file", handler.ReleaseFile)
+
+	e.GET(prefix+"/repo/:repo/todos", handler.Todos)
+	e.GET(prefix+"/repo/:repo/:ref/todos", handler.Todos)
+
+	e.GET(prefix+"/repo/:repo/commits", handler.FullCommits)
+	e.GET(prefix+"/repo/:repo/:ref/commits", handler.Commits)
 
-	e.GET("/user/:email", handler.User)
+	e.GET(prefix+"/repo/:repo/blob/*", handler.File)
+	e.GET(prefix+"/repo/:repo/blame/*", handler.Blame)
+	e.GET(prefix+"/repo/:repo/raw/*", handler.Raw)
+	e.GET(prefix+"/repo/:repo/commit/:commit/raw/*", handler.Raw)
+	e.GET(prefix+"/repo/:repo/commit/:commit/blame/*", handler.Blame)
+	e.GET(prefix+"/repo/:repo/commit/:commit/blob/*", handler.File)
+
+	e.GET(prefix+"/repo/:repo/tree/*", handler.FileTree)
+	e.GET(prefix+"/repo/:repo/commit/:commit/tree/*", handler.FileTree)
+
+	e.GET(prefix+"/repo/:repo/commit/:oidA", handler.Diff)
+
+	// BASE...HEAD
+	e.GET(prefix+"/repo/:repo/compare/*", handler.Compare)
+
+	e.GET(prefix+"/repo/:repo/history/:ref", handler.History)
+	e.GET(prefix+"/repo/:repo/history/:ref/*", handler.History)
+
+	e.GET(prefix+"/user/:email", handler.User)
 }
 
+// names can have slashes in them (for namespaces)
 func RepoPath(r *model.Repo) string {
 	return path.Join("/", "repo", r.Name)
 }
 
+func NamespacePath(ns string) string {
+	return path.Join("/", "repo", ns)
+}
+
 // TODO: clean this up and make CommitPath do the logic to
 // determine if commit is part of url
 func CommitPath(r *model.Repo, c *model.Commit) string {
 	return path.Join(RepoPath(r), "commit", c.Hash())
 }
 
+// escapePath escapes each part of a file's path, so names with ? or # in
+// them don't get cut off
+func escapePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	return strings.Join(segs, "/")
+}
+
+// CommitsPath links to the log of e (or every branch for nil). Branch
+// names are escaped into one segment, so feature/login can't be mistaken
+// for anything else
 func CommitsPath(r *model.Repo, e *model.Ref) string {
 	if e == nil {
 		return path.Join(RepoPath(r), "commits")
 	} else {
-		return path.Join(RepoPath(r), e.Name(), "commits")
+		return path.Join(RepoPath(r), url.PathEscape(e.NiceName()), "commits")
 	}
 }
 
+// BlobPath links to a file at c, or on the default branch for nil
 func BlobPath(r *model.Repo, c *model.Commit, b *model.Blob) string {
 	if c == nil {
-		return path.Join(RepoPath(r), "blob", b.Path)
+		return path.Join(RepoPath(r), "blob", escapePath(b.Path))
 	} else {
-		return path.Join(CommitPath(r, c), "blob", b.Path)
+		return path.Join(CommitPath(r, c), "blob", escapePath(b.Path))
 	}
 }
 
-func BlamePath(r *model.Repo, b *model.Blob) string {
-	return path.Join(RepoPath(r), "blame", b.Path)
+func RawPath(r *model.Repo, c *model.Commit, b *model.Blob) string {
+	if c == nil {
+		return path.Join(RepoPath(r), "raw", escapePath(b.Path))
+	}
+	return path.Join(CommitPath(r, c), "raw", escapePath(b.Path))
+}
+
+// ArchivePath links to a download of dir ("" for everything) at c
+func ArchivePath(r *model.Repo, c *model.Commit, dir string, format string) string {
+	if dir == "" || dir == "/" {
+		return path.Join(RepoPath(r), "archive", c.Hash()+"."+format)
+	}
+	return path.Join(RepoPath(r), "archive", c.Hash(), dir+"."+format)
+}
+
+// LinePath links to a line in a file at c
+func LinePath(r *model.Repo, c *model.Commit, filepath string, line int) string {
+	return BlobPath(r, c, &model.Blob{Path: filepath}) + "#L" + strconv.Itoa(line)
+}
+
+// BlamePath links to the blame of a file at c, or on the default branch
+// for nil
+func BlamePath(r *model.Repo, c *model.Commit, b *model.Blob) string {
+	if c == nil {
+		return path.Join(RepoPath(r), "blame", escapePath(b.Path))
+	}
+	return path.Join(CommitPath(r, c), "blame", escapePath(b.Path))
 }
 
 func UserPath(u *model.User) string {
 	return path.Join("/", "user", u.Email)
 }
 
-//TODO:
-//func TreePath(r *model.Repo, c *model.Commit, )
+// TreePath links to a directory at c
+func TreePath(r *model.Repo, c *model.Commit, dir string) string {
+	return path.Join(CommitPath(r, c), "tree", escapePath(dir))
+}
+
+// ComparePath links to what head would bring into base
+func ComparePath(r *model.Repo, base string, head string) string {
+	return path.Join(RepoPath(r), "compare", base+"..."+head)
+}
+
+// HistoryPath links to the commits that changed filepath ("" for all of
+// them), starting from rev
+func HistoryPath(r *model.Repo, rev string, filepath string) string {
+	return path.Join(RepoPath(r), "history", url.PathEscape(rev), escapePath(filepath))
+}
+
+// ReleasePath links to t's release. Tags with slashes are escaped into one
+// segment
+func ReleasePath(r *model.Repo, t *model.Tag) string {
+	return path.Join(RepoPath(r), "releases", url.PathEscape(t.ShortName()))
+}
+
+// ReleaseFilePath downloads a file attached to t's release
+func ReleaseFilePath(r *model.Repo, t *model.Tag, name string) string {
+	return path.Join(ReleasePath(r, t), "files", url.PathEscape(name))
+}